@@ -3,11 +3,14 @@ package main
 import (
 	"crypto/rand"
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/devkekops/gophermart/internal/app/config"
 	"github.com/devkekops/gophermart/internal/app/logger"
 	"github.com/devkekops/gophermart/internal/app/server"
+	"github.com/devkekops/gophermart/internal/app/storage"
 )
 
 func main() {
@@ -21,12 +24,57 @@ func main() {
 	}
 	secretKey := string(randBytes)
 
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Logger.Fatal().Err(err).Msg("")
+		return
+	}
+	instanceID := fmt.Sprintf("%s-%d-%x", hostname, os.Getpid(), randBytes[:4])
+
 	cfg := config.Config{
 		RunAddress:           "localhost:8081",
 		DatabaseURI:          "postgres://localhost:5432/gophermart",
 		AccrualSystemAddress: "http://localhost:8080",
 		SecretKey:            secretKey,
 		ClientTimeout:        5,
+		MaxAccrualAttempts:   10,
+		InstanceID:           instanceID,
+		PollInterval:         1,
+		LeaseTTL:             30,
+		AccrualBatchSize:     50,
+		ClientConcurrency:    4,
+		PollNewInterval:      5,
+		PollRegInterval:      10,
+		PollProcInterval:     5,
+		PollFailureInterval:  5,
+		PollRateLimitDelay:   10,
+		PollMaxInterval:      3600,
+		OrderMaxAge:          7 * 24 * 3600,
+		BulkOrdersLimit:      1000,
+		IdempotencyTTL:       24 * 3600,
+		IdempotencyLock:      60,
+		IdempotencyPurge:     3600,
+		RefundWindow:         24 * 3600,
+		ReversalPolicy:       storage.ReversalDebt,
+		RecheckWindow:        30 * 24 * 3600,
+		RecheckInterval:      6 * 3600,
+		PointsExpiringSoon:   30 * 24 * 3600,
+		PointsExpiryInterval: 3600,
+		TransferDailyLimit:   10000,
+		TransferDailyCount:   10,
+		TransferPendingTTL:   7 * 24 * 3600,
+		TransferExpiry:       600,
+		TierRecalcInterval:   3600,
+		ReferrerBonus:        100,
+		RefereeBonus:         50,
+		ReferralMonthlyLimit: 10,
+		ReferralMinAccrual:   1,
+		ReferralSweep:        600,
+		WithdrawalVelocity:   5,
+		WithdrawalWindow:     600,
+		HoldTTL:              30 * 60,
+		HoldExpiryInterval:   60,
+		GRPCAddress:          "localhost:3200",
 	}
 
 	if err := env.Parse(&cfg); err != nil {
@@ -37,7 +85,15 @@ func main() {
 	flag.StringVar(&cfg.RunAddress, "a", cfg.RunAddress, "run address")
 	flag.StringVar(&cfg.DatabaseURI, "d", cfg.DatabaseURI, "database URI")
 	flag.StringVar(&cfg.AccrualSystemAddress, "r", cfg.AccrualSystemAddress, "accrual system address")
+	flag.StringVar(&cfg.AccrualProvidersFile, "p", cfg.AccrualProvidersFile, "accrual providers config file (overrides -r)")
+	flag.StringVar(&cfg.LoyaltyTiersFile, "l", cfg.LoyaltyTiersFile, "loyalty tiers config file")
+	flag.StringVar(&cfg.BonusRulesFile, "b", cfg.BonusRulesFile, "bonus rules file, seeded when there are no rules yet")
 	flag.StringVar(&cfg.SecretKey, "s", cfg.SecretKey, "secret key")
+	flag.IntVar(&cfg.MaxAccrualAttempts, "m", cfg.MaxAccrualAttempts, "max accrual attempts before dead-lettering an order")
+	flag.StringVar(&cfg.AdminToken, "t", cfg.AdminToken, "admin API token")
+	flag.StringVar(&cfg.InstanceID, "i", cfg.InstanceID, "instance ID used for accrual leases")
+	flag.StringVar(&cfg.GRPCAddress, "g", cfg.GRPCAddress, "gRPC address (empty to disable)")
+	flag.StringVar(&cfg.AccrualWebhookSecret, "w", cfg.AccrualWebhookSecret, "accrual webhook HMAC secret")
 	flag.Parse()
 
 	logger.Logger.Fatal().Err(server.Serve(&cfg)).Msg("")
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+)
+
+type config struct {
+	Address    string `env:"GOPHERMART_ADDRESS"`
+	AdminToken string `env:"ADMIN_TOKEN"`
+}
+
+type deadLetter struct {
+	OrderID   string `json:"number"`
+	UserID    int64  `json:"user_id"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+	FailedAt  string `json:"failed_at"`
+}
+
+const usage = `usage: gophermartctl [-a address] [-t token] <command> [args]
+
+commands:
+  dlq list                list dead-lettered accrual tasks
+  dlq show <number>       show dead letter for order <number>
+  dlq redrive <number>    re-enqueue order <number> for accrual polling
+  dlq redrive -all        re-enqueue every dead-lettered order
+`
+
+func main() {
+	cfg := config{
+		Address: "http://localhost:8081",
+	}
+
+	if err := env.Parse(&cfg); err != nil {
+		fatal(err)
+	}
+
+	flag.StringVar(&cfg.Address, "a", cfg.Address, "gophermart base URL")
+	flag.StringVar(&cfg.AdminToken, "t", cfg.AdminToken, "admin API token")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 || args[0] != "dlq" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c := &adminClient{
+		address:    cfg.Address,
+		token:      cfg.AdminToken,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	var err error
+	switch args[1] {
+	case "list":
+		err = c.list()
+	case "show":
+		if len(args) != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = c.show(args[2])
+	case "redrive":
+		if len(args) != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if args[2] == "-all" {
+			err = c.redriveAll()
+		} else {
+			err = c.redrive(args[2])
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatal(err)
+	}
+}
+
+type adminClient struct {
+	address    string
+	token      string
+	httpClient *http.Client
+}
+
+func (c *adminClient) do(method string, path string) (int, []byte, error) {
+	req, err := http.NewRequest(method, c.address+"/api/admin"+path, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return res.StatusCode, nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+		return res.StatusCode, body, nil
+	default:
+		return res.StatusCode, body, fmt.Errorf("%s %s: %d %s", method, path, res.StatusCode, bytes.TrimSpace(body))
+	}
+}
+
+func (c *adminClient) list() error {
+	statusCode, body, err := c.do(http.MethodGet, "/dead-letters/")
+	if err != nil {
+		return err
+	}
+	if statusCode == http.StatusNoContent {
+		fmt.Println("no dead letters")
+		return nil
+	}
+
+	var deadLetters []deadLetter
+	if err := json.Unmarshal(body, &deadLetters); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NUMBER\tUSER\tATTEMPTS\tFAILED AT\tLAST ERROR")
+	for _, dl := range deadLetters {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", dl.OrderID, dl.UserID, dl.Attempts, dl.FailedAt, dl.LastError)
+	}
+	return tw.Flush()
+}
+
+func (c *adminClient) show(number string) error {
+	_, body, err := c.do(http.MethodGet, "/dead-letters/"+number)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		return err
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+func (c *adminClient) redrive(number string) error {
+	_, _, err := c.do(http.MethodPost, "/dead-letters/"+number+"/redrive")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("order %s re-enqueued\n", number)
+	return nil
+}
+
+func (c *adminClient) redriveAll() error {
+	_, body, err := c.do(http.MethodPost, "/dead-letters/redrive")
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Redriven int `json:"redriven"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+	fmt.Printf("%d orders re-enqueued\n", result.Redriven)
+	return nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Сессия - hex(userID || HMAC-SHA256(userID)), общая для cookie HTTP API и метаданных gRPC.
+
+const signatureLength = 32
+
+func PasswordHash(password string) string {
+	h := sha256.New()
+	h.Write([]byte(password))
+	hash := h.Sum(nil)
+	passwordHash := hex.EncodeToString(hash)
+	return passwordHash
+}
+
+func CreateSession(userID string, secretKey string) string {
+	userIDBytes := []byte(userID)
+
+	key := sha256.Sum256([]byte(secretKey))
+	h := hmac.New(sha256.New, key[:])
+	h.Write(userIDBytes)
+	dst := h.Sum(nil)
+
+	sessionBytes := append(userIDBytes[:], dst[:]...)
+	session := hex.EncodeToString(sessionBytes)
+
+	return session
+}
+
+// CheckSession проверяет подпись сессии и возвращает userID.
+func CheckSession(value string, secretKey string) (string, error) {
+	session, err := hex.DecodeString(value)
+	if err != nil {
+		return "", err
+	}
+
+	if len(session) <= signatureLength {
+		return "", fmt.Errorf("invalid cookie length")
+	}
+
+	userIDLength := len(session) - signatureLength
+	userID := session[:userIDLength]
+
+	key := sha256.Sum256([]byte(secretKey))
+	h := hmac.New(sha256.New, key[:])
+	h.Write(userID)
+	sign := h.Sum(nil)
+
+	if hmac.Equal(sign, session[userIDLength:]) {
+		return string(userID), nil
+	} else {
+		return "", fmt.Errorf("invalid signature")
+	}
+}
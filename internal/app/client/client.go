@@ -1,16 +1,32 @@
 package client
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	baseQuery  = "/api/orders/"
+	batchQuery = "/api/orders/batch"
 )
 
-const baseQuery = "/api/orders/"
+const defaultRetryAfter = 10 * time.Second
+
+var errBatchUnsupported = errors.New("accrual system does not support batch lookups")
 
 type AccrualResponse struct {
 	StatusCode int
+	Err        error   `json:"-"`
 	Order      string  `json:"order"`
 	Status     string  `json:"status"`
 	Accrual    float64 `json:"accrual"`
@@ -18,27 +34,79 @@ type AccrualResponse struct {
 
 type Client interface {
 	GetAccrualInfo(number string) (AccrualResponse, error)
+	// GetAccrualInfoBatch возвращает ответы в том же порядке, что и numbers.
+	// Ошибка конкретного номера кладётся в AccrualResponse.Err.
+	GetAccrualInfoBatch(numbers []string) ([]AccrualResponse, error)
 }
 
 type cli struct {
-	host       string
-	httpClient *http.Client
+	host             string
+	httpClient       *http.Client
+	concurrency      int
+	limiter          *rate.Limiter
+	batchUnsupported int32
+	pausedUntil      int64
 }
 
-func NewCli(host string, timeout int) Client {
+// NewCli создаёт клиента системы расчёта. rateLimit - запросов в секунду, 0 - без ограничения.
+func NewCli(host string, timeout int, concurrency int, rateLimit float64) Client {
 	client := &http.Client{
 		Timeout: time.Duration(timeout * int(time.Second)),
 	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if rateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(rateLimit), concurrency)
+	}
 	return &cli{
-		host:       host,
-		httpClient: client,
+		host:        host,
+		httpClient:  client,
+		concurrency: concurrency,
+		limiter:     limiter,
+	}
+}
+
+// paused сообщает, что система ответила 429 и просила не приходить до pausedUntil.
+func (c *cli) paused() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&c.pausedUntil)
+}
+
+func (c *cli) pause(res *http.Response) {
+	retryAfter := defaultRetryAfter
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
 	}
+	atomic.StoreInt64(&c.pausedUntil, time.Now().Add(retryAfter).UnixNano())
+}
+
+func (c *cli) do(req *http.Request) (*http.Response, error) {
+	if err := c.limiter.Wait(context.Background()); err != nil {
+		return nil, err
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		c.pause(res)
+	}
+	return res, nil
 }
 
 func (c *cli) GetAccrualInfo(number string) (AccrualResponse, error) {
 	var accrualResp AccrualResponse
-	baseURL := c.host + baseQuery + number
-	res, err := c.httpClient.Get(baseURL)
+	if c.paused() {
+		accrualResp.StatusCode = http.StatusTooManyRequests
+		return accrualResp, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, c.host+baseQuery+number, nil)
+	if err != nil {
+		return accrualResp, err
+	}
+	res, err := c.do(req)
 	if err != nil {
 		return accrualResp, err
 	}
@@ -56,3 +124,110 @@ func (c *cli) GetAccrualInfo(number string) (AccrualResponse, error) {
 	}
 	return accrualResp, nil
 }
+
+func (c *cli) GetAccrualInfoBatch(numbers []string) ([]AccrualResponse, error) {
+	if c.paused() {
+		responses := make([]AccrualResponse, len(numbers))
+		for i, number := range numbers {
+			responses[i] = AccrualResponse{StatusCode: http.StatusTooManyRequests, Order: number}
+		}
+		return responses, nil
+	}
+
+	if len(numbers) > 1 && atomic.LoadInt32(&c.batchUnsupported) == 0 {
+		responses, err := c.getBatch(numbers)
+		if !errors.Is(err, errBatchUnsupported) {
+			return responses, err
+		}
+		atomic.StoreInt32(&c.batchUnsupported, 1)
+	}
+
+	return c.fanOut(numbers), nil
+}
+
+func (c *cli) getBatch(numbers []string) ([]AccrualResponse, error) {
+	body, err := json.Marshal(numbers)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.host+batchQuery, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, errBatchUnsupported
+	}
+
+	responses := make([]AccrualResponse, len(numbers))
+	if res.StatusCode != http.StatusOK {
+		for i, number := range numbers {
+			responses[i] = AccrualResponse{StatusCode: res.StatusCode, Order: number}
+		}
+		return responses, nil
+	}
+
+	var found []AccrualResponse
+	if err := json.NewDecoder(res.Body).Decode(&found); err != nil {
+		return nil, err
+	}
+
+	byNumber := make(map[string]AccrualResponse, len(found))
+	for _, accrualResp := range found {
+		byNumber[accrualResp.Order] = accrualResp
+	}
+
+	for i, number := range numbers {
+		accrualResp, ok := byNumber[number]
+		if ok {
+			accrualResp.StatusCode = http.StatusOK
+		} else {
+			accrualResp = AccrualResponse{StatusCode: http.StatusNoContent, Order: number}
+		}
+		responses[i] = accrualResp
+	}
+
+	return responses, nil
+}
+
+// fanOut опрашивает номера по одному, не больше concurrency запросов одновременно.
+// После первого 429 оставшиеся номера не запрашиваются и тоже получают 429.
+func (c *cli) fanOut(numbers []string) []AccrualResponse {
+	responses := make([]AccrualResponse, len(numbers))
+	sem := make(chan struct{}, c.concurrency)
+	var limited int32
+	var wg sync.WaitGroup
+
+	for i, number := range numbers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, number string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if atomic.LoadInt32(&limited) == 1 {
+				responses[i] = AccrualResponse{StatusCode: http.StatusTooManyRequests, Order: number}
+				return
+			}
+
+			accrualResp, err := c.GetAccrualInfo(number)
+			accrualResp.Err = err
+			if accrualResp.StatusCode == http.StatusTooManyRequests {
+				atomic.StoreInt32(&limited, 1)
+			}
+			responses[i] = accrualResp
+		}(i, number)
+	}
+	wg.Wait()
+
+	return responses
+}
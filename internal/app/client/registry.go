@@ -0,0 +1,111 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const DefaultProvider = "default"
+
+type ProviderConfig struct {
+	Name        string  `json:"name"`
+	Address     string  `json:"address"`
+	Timeout     int     `json:"timeout"`
+	Concurrency int     `json:"concurrency"`
+	RateLimit   float64 `json:"rate_limit"`
+}
+
+// RouteConfig направляет заказ в Provider, если совпали все заданные условия.
+// Пустые условия не проверяются.
+type RouteConfig struct {
+	Provider string `json:"provider"`
+	Prefix   string `json:"prefix"`
+	Length   int    `json:"length"`
+	Merchant string `json:"merchant"`
+}
+
+type RegistryConfig struct {
+	Default   string           `json:"default"`
+	Providers []ProviderConfig `json:"providers"`
+	Routes    []RouteConfig    `json:"routes"`
+}
+
+func LoadRegistryConfig(path string) (RegistryConfig, error) {
+	var cfg RegistryConfig
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+type Registry struct {
+	defaultProvider string
+	clients         map[string]Client
+	routes          []RouteConfig
+}
+
+func NewRegistry(cfg RegistryConfig) (*Registry, error) {
+	r := &Registry{
+		defaultProvider: cfg.Default,
+		clients:         make(map[string]Client, len(cfg.Providers)),
+		routes:          cfg.Routes,
+	}
+
+	for _, p := range cfg.Providers {
+		if p.Name == "" {
+			return nil, fmt.Errorf("accrual provider without name")
+		}
+		if _, ok := r.clients[p.Name]; ok {
+			return nil, fmt.Errorf("duplicate accrual provider %q", p.Name)
+		}
+		r.clients[p.Name] = NewCli(p.Address, p.Timeout, p.Concurrency, p.RateLimit)
+	}
+
+	if r.defaultProvider == "" && len(cfg.Providers) == 1 {
+		r.defaultProvider = cfg.Providers[0].Name
+	}
+	if _, ok := r.clients[r.defaultProvider]; !ok {
+		return nil, fmt.Errorf("unknown default accrual provider %q", r.defaultProvider)
+	}
+	for _, route := range r.routes {
+		if _, ok := r.clients[route.Provider]; !ok {
+			return nil, fmt.Errorf("route to unknown accrual provider %q", route.Provider)
+		}
+	}
+
+	return r, nil
+}
+
+// Route выбирает провайдера для заказа по первому подходящему правилу.
+func (r *Registry) Route(number string, merchant string) string {
+	for _, route := range r.routes {
+		if route.Prefix != "" && !strings.HasPrefix(number, route.Prefix) {
+			continue
+		}
+		if route.Length != 0 && len(number) != route.Length {
+			continue
+		}
+		if route.Merchant != "" && route.Merchant != merchant {
+			continue
+		}
+		return route.Provider
+	}
+	return r.defaultProvider
+}
+
+// Get возвращает клиента провайдера. Неизвестные (например, удалённые из конфигурации)
+// и пустые имена обслуживаются провайдером по умолчанию.
+func (r *Registry) Get(name string) (string, Client) {
+	if c, ok := r.clients[name]; ok {
+		return name, c
+	}
+	return r.defaultProvider, r.clients[r.defaultProvider]
+}
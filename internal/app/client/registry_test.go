@@ -0,0 +1,100 @@
+package client
+
+import (
+	"testing"
+)
+
+func testRegistry(t *testing.T) *Registry {
+	t.Helper()
+	registry, err := NewRegistry(RegistryConfig{
+		Default: "main",
+		Providers: []ProviderConfig{
+			{Name: "main", Address: "http://main"},
+			{Name: "partner", Address: "http://partner"},
+			{Name: "short", Address: "http://short"},
+		},
+		Routes: []RouteConfig{
+			{Provider: "partner", Merchant: "acme"},
+			{Provider: "partner", Prefix: "42", Length: 10},
+			{Provider: "short", Length: 6},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func TestRegistryRoute(t *testing.T) {
+	registry := testRegistry(t)
+
+	tests := []struct {
+		name     string
+		number   string
+		merchant string
+		want     string
+	}{
+		{"no matching route", "12345678903", "", "main"},
+		{"merchant route", "12345678903", "acme", "partner"},
+		{"other merchant", "12345678903", "shop", "main"},
+		{"prefix and length", "4200000008", "", "partner"},
+		{"prefix with other length", "42000000000", "", "main"},
+		{"length only", "123455", "", "short"},
+		{"first matching route wins", "123455", "acme", "partner"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := registry.Route(tt.number, tt.merchant); got != tt.want {
+				t.Errorf("Route(%q, %q) = %q, want %q", tt.number, tt.merchant, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryGet(t *testing.T) {
+	registry := testRegistry(t)
+
+	tests := []struct {
+		name     string
+		provider string
+		want     string
+	}{
+		{"known provider", "partner", "partner"},
+		{"empty name", "", "main"},
+		{"removed provider", "legacy", "main"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, c := registry.Get(tt.provider)
+			if got != tt.want {
+				t.Errorf("Get(%q) = %q, want %q", tt.provider, got, tt.want)
+			}
+			if c != registry.clients[tt.want] {
+				t.Errorf("Get(%q) returned client of another provider", tt.provider)
+			}
+		})
+	}
+}
+
+func TestNewRegistryErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  RegistryConfig
+	}{
+		{"no providers", RegistryConfig{}},
+		{"unknown default", RegistryConfig{Default: "x", Providers: []ProviderConfig{{Name: "main"}}}},
+		{"duplicate provider", RegistryConfig{Providers: []ProviderConfig{{Name: "main"}, {Name: "main"}}, Default: "main"}},
+		{"provider without name", RegistryConfig{Providers: []ProviderConfig{{}}}},
+		{"route to unknown provider", RegistryConfig{Providers: []ProviderConfig{{Name: "main"}}, Routes: []RouteConfig{{Provider: "x"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRegistry(tt.cfg); err == nil {
+				t.Error("NewRegistry() error = nil, want error")
+			}
+		})
+	}
+}
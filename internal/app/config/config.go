@@ -2,8 +2,59 @@ package config
 
 type Config struct {
 	RunAddress           string `env:"RUN_ADDRESS"`
+	GRPCAddress          string `env:"GRPC_ADDRESS"`
 	DatabaseURI          string `env:"DATABASE_URI"`
 	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	AccrualProvidersFile string `env:"ACCRUAL_PROVIDERS_FILE"`
 	SecretKey            string `env:"SECRET_KEY"`
 	ClientTimeout        int
+	MaxAccrualAttempts   int    `env:"MAX_ACCRUAL_ATTEMPTS"`
+	AdminToken           string `env:"ADMIN_TOKEN"`
+	InstanceID           string `env:"INSTANCE_ID"`
+	PollInterval         int    `env:"ACCRUAL_POLL_INTERVAL"`
+	LeaseTTL             int    `env:"ACCRUAL_LEASE_TTL"`
+	AccrualWebhookSecret string `env:"ACCRUAL_WEBHOOK_SECRET"`
+	AccrualBatchSize     int    `env:"ACCRUAL_BATCH_SIZE"`
+	ClientConcurrency    int    `env:"ACCRUAL_CLIENT_CONCURRENCY"`
+	PollNewInterval      int    `env:"POLL_NEW_INTERVAL"`
+	PollRegInterval      int    `env:"POLL_REGISTERED_INTERVAL"`
+	PollProcInterval     int    `env:"POLL_PROCESSING_INTERVAL"`
+	PollFailureInterval  int    `env:"POLL_FAILURE_INTERVAL"`
+	PollRateLimitDelay   int    `env:"POLL_RATE_LIMIT_DELAY"`
+	PollMaxInterval      int    `env:"POLL_MAX_INTERVAL"`
+	OrderMaxAge          int    `env:"ORDER_MAX_AGE"`
+	BulkOrdersLimit      int    `env:"BULK_ORDERS_LIMIT"`
+	IdempotencyTTL       int    `env:"IDEMPOTENCY_TTL"`
+	IdempotencyLock      int    `env:"IDEMPOTENCY_LOCK_TIMEOUT"`
+	IdempotencyPurge     int    `env:"IDEMPOTENCY_PURGE_INTERVAL"`
+	RefundWindow         int    `env:"REFUND_WINDOW"`
+	ReversalPolicy       string `env:"ACCRUAL_REVERSAL_POLICY"`
+	RecheckWindow        int    `env:"ACCRUAL_RECHECK_WINDOW"`
+	RecheckInterval      int    `env:"ACCRUAL_RECHECK_INTERVAL"`
+	PointsTTLMonths      int    `env:"POINTS_TTL_MONTHS"`
+	PointsExpiringSoon   int    `env:"POINTS_EXPIRING_SOON"`
+	PointsExpiryInterval int    `env:"POINTS_EXPIRY_INTERVAL"`
+
+	// Движение баллов: переводы, уровни, рефералы, лимиты списаний и блокировки.
+	TransferDailyLimit   float64 `env:"TRANSFER_DAILY_LIMIT"`
+	TransferDailyCount   int     `env:"TRANSFER_DAILY_COUNT"`
+	TransferPendingTTL   int     `env:"TRANSFER_PENDING_TTL"`
+	TransferExpiry       int     `env:"TRANSFER_EXPIRY_INTERVAL"`
+	LoyaltyTiersFile     string  `env:"LOYALTY_TIERS_FILE"`
+	BonusRulesFile       string  `env:"BONUS_RULES_FILE"`
+	TierRecalcInterval   int     `env:"TIER_RECALC_INTERVAL"`
+	ReferrerBonus        float64 `env:"REFERRER_BONUS"`
+	RefereeBonus         float64 `env:"REFEREE_BONUS"`
+	ReferralMonthlyLimit int     `env:"REFERRAL_MONTHLY_LIMIT"`
+	ReferralMinAccrual   float64 `env:"REFERRAL_MIN_ACCRUAL"`
+	ReferralSweep        int     `env:"REFERRAL_SWEEP_INTERVAL"`
+	WithdrawalMaxSum     float64 `env:"WITHDRAWAL_MAX_SUM"`
+	WithdrawalDailyLimit float64 `env:"WITHDRAWAL_DAILY_LIMIT"`
+	WithdrawalDailyCount int     `env:"WITHDRAWAL_DAILY_COUNT"`
+	WithdrawalMinAge     int     `env:"WITHDRAWAL_MIN_ACCOUNT_AGE"`
+	WithdrawalVelocity   int     `env:"WITHDRAWAL_VELOCITY_COUNT"`
+	WithdrawalWindow     int     `env:"WITHDRAWAL_VELOCITY_WINDOW"`
+	WithdrawalReviewSum  float64 `env:"WITHDRAWAL_REVIEW_SUM"`
+	HoldTTL              int     `env:"HOLD_TTL"`
+	HoldExpiryInterval   int     `env:"HOLD_EXPIRY_INTERVAL"`
 }
@@ -1,19 +1,195 @@
 package entity
 
 type Order struct {
-	OrderID    string  `json:"number" db:"order_id"`
-	Status     string  `json:"status" db:"status"`
-	Accrual    float64 `json:"accrual,omitempty" db:"accrual"`
-	UploadedAt string  `json:"uploaded_at" db:"uploaded_at"`
+	OrderID     string       `json:"number" db:"order_id"`
+	Status      string       `json:"status" db:"status"`
+	Accrual     float64      `json:"accrual,omitempty" db:"accrual"`
+	UploadedAt  string       `json:"uploaded_at" db:"uploaded_at"`
+	Adjustments []Adjustment `json:"adjustments,omitempty" db:"-"`
 }
 
+// OrderUpdate - изменение статуса заказа в потоке событий пользователя.
+type OrderUpdate struct {
+	OrderID string  `json:"number"`
+	Status  string  `json:"status"`
+	Accrual float64 `json:"accrual,omitempty"`
+}
+
+type Adjustment struct {
+	OrderID    string  `json:"-" db:"order_id"`
+	Accrual    float64 `json:"accrual" db:"accrual"`
+	Delta      float64 `json:"delta" db:"delta"`
+	Debt       float64 `json:"debt,omitempty" db:"debt"`
+	Reason     string  `json:"reason" db:"reason"`
+	AdjustedAt string  `json:"adjusted_at" db:"created_at"`
+}
+
+// Balance - current совпадает с available: заблокированные под заказы баллы (held) в него не входят.
 type Balance struct {
-	Current   float64 `json:"current" db:"current"`
-	Withdrawn float64 `json:"withdrawn" db:"withdrawn"`
+	Current      float64          `json:"current" db:"current"`
+	Available    float64          `json:"available" db:"available"`
+	Held         float64          `json:"held" db:"held"`
+	Withdrawn    float64          `json:"withdrawn" db:"withdrawn"`
+	Debt         float64          `json:"debt,omitempty" db:"debt"`
+	ExpiringSoon []ExpiringPoints `json:"expiring_soon,omitempty" db:"-"`
+}
+
+type ExpiringPoints struct {
+	Amount    float64 `json:"amount" db:"amount"`
+	ExpiresOn string  `json:"expires_on" db:"expires_on"`
 }
 
 type Withdrawal struct {
-	OrderID     string  `json:"order" db:"order_id"`
+	OrderID      string  `json:"order" db:"order_id"`
+	Sum          float64 `json:"sum" db:"sum"`
+	Status       string  `json:"status" db:"status"`
+	ProcessedAt  string  `json:"processed_at" db:"processed_at"`
+	RefundedAt   *string `json:"refunded_at,omitempty" db:"refunded_at"`
+	RefundReason string  `json:"refund_reason,omitempty" db:"refund_reason"`
+}
+
+type DeadLetter struct {
+	OrderID   string `json:"number" db:"order_id"`
+	UserID    int64  `json:"user_id" db:"user_id"`
+	Attempts  int    `json:"attempts" db:"attempts"`
+	LastError string `json:"last_error" db:"last_error"`
+	FailedAt  string `json:"failed_at" db:"failed_at"`
+}
+
+type ReviewOrder struct {
+	OrderID    string `json:"number" db:"order_id"`
+	UserID     int64  `json:"user_id" db:"user_id"`
+	Status     string `json:"status" db:"status"`
+	Provider   string `json:"provider" db:"provider"`
+	Polls      int    `json:"polls" db:"polls"`
+	Attempts   int    `json:"attempts" db:"attempts"`
+	LastError  string `json:"last_error" db:"last_error"`
+	UploadedAt string `json:"uploaded_at" db:"uploaded_at"`
+	FlaggedAt  string `json:"flagged_at" db:"review_flagged_at"`
+}
+
+type ReviewWithdrawal struct {
+	OrderID      string  `json:"order" db:"order_id"`
+	UserID       int64   `json:"user_id" db:"user_id"`
+	Login        string  `json:"login" db:"login"`
+	Sum          float64 `json:"sum" db:"sum"`
+	ProcessedAt  string  `json:"processed_at" db:"processed_at"`
+	ReviewReason string  `json:"review_reason" db:"review_reason"`
+}
+
+type WithdrawalTotals struct {
+	Count int     `json:"count" db:"count"`
+	Sum   float64 `json:"sum" db:"sum"`
+}
+
+type StatementEntry struct {
+	Date    string  `json:"date"`
+	Type    string  `json:"type"`
+	OrderID string  `json:"order"`
+	Amount  float64 `json:"amount"`
+	Balance float64 `json:"balance"`
+}
+
+type OrderUploadResult struct {
+	OrderID string `json:"number"`
+	Result  string `json:"result"`
+}
+
+type IdempotentResponse struct {
+	RequestHash string `db:"request_hash"`
+	StatusCode  int    `db:"status_code"`
+	ContentType string `db:"content_type"`
+	Body        []byte `db:"body"`
+}
+
+type HistoryEntry struct {
+	Date    string  `json:"date" db:"at"`
+	Type    string  `json:"type" db:"kind"`
+	OrderID string  `json:"order" db:"order_id"`
+	Amount  float64 `json:"amount" db:"amount"`
+	Reason  string  `json:"reason,omitempty" db:"reason"`
+	Ref     string  `json:"-" db:"ref"`
+}
+
+const (
+	TransferOut = "out"
+	TransferIn  = "in"
+)
+
+type Transfer struct {
+	ID          int64   `json:"id" db:"id"`
+	Direction   string  `json:"direction" db:"direction"`
+	Counterpart string  `json:"counterpart" db:"counterpart"`
 	Sum         float64 `json:"sum" db:"sum"`
-	ProcessedAt string  `json:"processed_at" db:"processed_at"`
+	Comment     string  `json:"comment,omitempty" db:"comment"`
+	Status      string  `json:"status" db:"status"`
+	CreatedAt   string  `json:"created_at" db:"created_at"`
+	ResolvedAt  *string `json:"resolved_at,omitempty" db:"resolved_at"`
+}
+
+type Profile struct {
+	Login         string  `json:"login"`
+	Tier          string  `json:"tier"`
+	Multiplier    float64 `json:"multiplier"`
+	Accrued       float64 `json:"accrued"`
+	Since         string  `json:"accrued_since"`
+	NextTier      string  `json:"next_tier,omitempty"`
+	NextThreshold float64 `json:"next_threshold,omitempty"`
+	ToNextTier    float64 `json:"to_next_tier,omitempty"`
+}
+
+type RuleFiring struct {
+	RuleID   int64   `json:"rule_id" db:"rule_id"`
+	RuleName string  `json:"rule_name" db:"rule_name"`
+	Bonus    float64 `json:"bonus" db:"bonus"`
+	FiredAt  string  `json:"fired_at" db:"fired_at"`
+}
+
+type Referral struct {
+	Login        string  `json:"login" db:"login"`
+	Status       string  `json:"status" db:"status"`
+	Reason       string  `json:"reason,omitempty" db:"reason"`
+	Bonus        float64 `json:"bonus,omitempty" db:"bonus"`
+	RegisteredAt string  `json:"registered_at" db:"created_at"`
+	ResolvedAt   *string `json:"resolved_at,omitempty" db:"resolved_at"`
+}
+
+type Referrals struct {
+	Code      string     `json:"code"`
+	Referrals []Referral `json:"referrals"`
+}
+
+type VoucherBatch struct {
+	ID         int64    `json:"id" db:"id"`
+	Value      float64  `json:"value" db:"value"`
+	UsageLimit int      `json:"usage_limit" db:"usage_limit"`
+	ExpiresAt  *string  `json:"expires_at,omitempty" db:"expires_at"`
+	Count      int      `json:"count" db:"count"`
+	Redeemed   int      `json:"redeemed" db:"redeemed"`
+	CreatedAt  string   `json:"created_at" db:"created_at"`
+	Codes      []string `json:"codes,omitempty" db:"-"`
+}
+
+type Redemption struct {
+	Value      float64 `json:"value"`
+	RedeemedAt string  `json:"redeemed_at"`
+}
+
+type Hold struct {
+	OrderID    string   `json:"order" db:"order_id"`
+	Sum        float64  `json:"sum" db:"sum"`
+	Captured   *float64 `json:"captured,omitempty" db:"captured"`
+	Status     string   `json:"status" db:"status"`
+	CreatedAt  string   `json:"created_at" db:"created_at"`
+	ExpiresAt  string   `json:"expires_at" db:"expires_at"`
+	ResolvedAt *string  `json:"resolved_at,omitempty" db:"resolved_at"`
+}
+
+// APIKey - ключ доступа терминала. Key заполнен только в ответе на создание ключа.
+type APIKey struct {
+	ID         int64   `json:"id" db:"id"`
+	Name       string  `json:"name" db:"name"`
+	Key        string  `json:"key,omitempty" db:"-"`
+	CreatedAt  string  `json:"created_at" db:"created_at"`
+	LastUsedAt *string `json:"last_used_at,omitempty" db:"last_used_at"`
 }
@@ -0,0 +1,76 @@
+package events
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+const (
+	OrderEvent   = "order"
+	BalanceEvent = "balance"
+)
+
+const subscriberBuffer = 16
+
+// Event - изменение, касающееся одного пользователя. Data - JSON заказа или баланса.
+type Event struct {
+	UserID string          `json:"user_id"`
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// Bus раздаёт события подписчикам этой реплики. Подписчик, не успевающий читать события,
+// отключается (его канал закрывается), чтобы не задерживать остальных: клиент переподключится
+// и получит актуальное состояние заново.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[string]map[chan Event]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[string]map[chan Event]struct{})}
+}
+
+// Subscribe подписывает на события пользователя. Возвращённую функцию нужно вызвать, когда подписка больше не нужна.
+func (b *Bus) Subscribe(userID string) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subs[userID] == nil {
+		b.subs[userID] = make(map[chan Event]struct{})
+	}
+	b.subs[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(userID, ch)
+	}
+}
+
+func (b *Bus) Publish(ev Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs[ev.UserID] {
+		select {
+		case ch <- ev:
+		default:
+			b.remove(ev.UserID, ch)
+		}
+	}
+}
+
+// remove вызывается под mu и закрывает канал не больше одного раза.
+func (b *Bus) remove(userID string, ch chan Event) {
+	subs := b.subs[userID]
+	if _, ok := subs[ch]; !ok {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(b.subs, userID)
+	}
+}
@@ -0,0 +1,85 @@
+package events
+
+import (
+	"testing"
+)
+
+func drain(ch <-chan Event) (int, bool) {
+	n := 0
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return n, true
+			}
+			n++
+		default:
+			return n, false
+		}
+	}
+}
+
+func TestBusPublish(t *testing.T) {
+	bus := NewBus()
+	first, unsubscribeFirst := bus.Subscribe("1")
+	defer unsubscribeFirst()
+	second, unsubscribeSecond := bus.Subscribe("1")
+	defer unsubscribeSecond()
+	other, unsubscribeOther := bus.Subscribe("2")
+	defer unsubscribeOther()
+
+	bus.Publish(Event{UserID: "1", Type: BalanceEvent})
+	bus.Publish(Event{UserID: "3", Type: OrderEvent})
+
+	for name, tt := range map[string]struct {
+		ch   <-chan Event
+		want int
+	}{"first": {first, 1}, "second": {second, 1}, "other user": {other, 0}} {
+		if got, closed := drain(tt.ch); got != tt.want || closed {
+			t.Errorf("%s subscriber got %d events (closed %v), want %d", name, got, closed, tt.want)
+		}
+	}
+}
+
+func TestBusOverflow(t *testing.T) {
+	bus := NewBus()
+	slow, unsubscribeSlow := bus.Subscribe("1")
+	fast, unsubscribeFast := bus.Subscribe("1")
+	defer unsubscribeFast()
+
+	for i := 0; i < subscriberBuffer; i++ {
+		bus.Publish(Event{UserID: "1", Type: OrderEvent})
+		if got, _ := drain(fast); got != 1 {
+			t.Fatalf("fast subscriber got %d events, want 1", got)
+		}
+	}
+	// буфер медленного подписчика полон: следующее событие отключает только его
+	bus.Publish(Event{UserID: "1", Type: OrderEvent})
+
+	if got, closed := drain(slow); got != subscriberBuffer || !closed {
+		t.Errorf("slow subscriber got %d events (closed %v), want %d and closed", got, closed, subscriberBuffer)
+	}
+	if got, closed := drain(fast); got != 1 || closed {
+		t.Errorf("fast subscriber got %d events (closed %v), want 1 and open", got, closed)
+	}
+
+	// отписка после отключения не закрывает канал повторно
+	unsubscribeSlow()
+}
+
+func TestBusUnsubscribe(t *testing.T) {
+	bus := NewBus()
+	ch, unsubscribe := bus.Subscribe("1")
+
+	unsubscribe()
+	unsubscribe()
+	if _, closed := drain(ch); !closed {
+		t.Error("channel is open after unsubscribe")
+	}
+	if len(bus.subs) != 0 {
+		t.Errorf("bus keeps %d users after the last unsubscribe", len(bus.subs))
+	}
+
+	// публикация без подписчиков ничего не делает
+	bus.Publish(Event{UserID: "1", Type: BalanceEvent})
+}
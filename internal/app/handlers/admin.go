@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	noDeadLetters          = "No dead letters"
+	deadLetterNotFound     = "Dead letter not found"
+	noOrdersForReview      = "No orders for review"
+	orderNotFlagged        = "Order is not flagged for review"
+	orderNotProcessed      = "Order is not processed"
+	noWithdrawalsForReview = "No withdrawals for review"
+	withdrawalNotInReview  = "Withdrawal is not under review"
+	numberURLParam         = "number"
+)
+
+type RedriveResult struct {
+	Redriven int `json:"redriven"`
+}
+
+type AdjustRequest struct {
+	Accrual *float64 `json:"accrual"`
+	Reason  string   `json:"reason"`
+}
+
+func (bh *BaseHandler) getDeadLetters() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		deadLetters, err := bh.repo.GetDeadLetters()
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		if deadLetters == nil {
+			http.Error(w, noDeadLetters, http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, deadLetters)
+	}
+}
+
+func (bh *BaseHandler) getDeadLetter() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		orderID := chi.URLParam(req, numberURLParam)
+
+		deadLetter, err := bh.repo.GetDeadLetter(orderID)
+		if err != nil {
+			if errors.Is(err, storage.ErrDeadLetterNotFound) {
+				http.Error(w, deadLetterNotFound, http.StatusNotFound)
+				return
+			}
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, deadLetter)
+	}
+}
+
+func (bh *BaseHandler) redriveDeadLetter() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		orderID := chi.URLParam(req, numberURLParam)
+
+		err := bh.repo.RedriveDeadLetter(orderID)
+		if err != nil {
+			if errors.Is(err, storage.ErrDeadLetterNotFound) {
+				http.Error(w, deadLetterNotFound, http.StatusNotFound)
+				return
+			}
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (bh *BaseHandler) redriveDeadLetters() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		redriven, err := bh.repo.RedriveDeadLetters()
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, RedriveResult{Redriven: redriven})
+	}
+}
+
+func (bh *BaseHandler) getOrdersForReview() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		orders, err := bh.repo.GetOrdersForReview()
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		if orders == nil {
+			http.Error(w, noOrdersForReview, http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, orders)
+	}
+}
+
+func (bh *BaseHandler) resumeOrder() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		orderID := chi.URLParam(req, numberURLParam)
+
+		err := bh.repo.ResumeOrder(orderID)
+		if err != nil {
+			if errors.Is(err, storage.ErrOrderNotFound) {
+				http.Error(w, orderNotFlagged, http.StatusNotFound)
+				return
+			}
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (bh *BaseHandler) adminRefundWithdrawal() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		refund, err := decodeRefundRequest(req)
+		if err != nil {
+			http.Error(w, invalidJSON, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		err = bh.repo.RefundWithdrawal(chi.URLParam(req, numberURLParam), "", refund.Reason, 0)
+		if err != nil {
+			writeRefundError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (bh *BaseHandler) adjustAccrual() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var adjust AdjustRequest
+		if err := json.NewDecoder(req.Body).Decode(&adjust); err != nil {
+			http.Error(w, invalidJSON, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+		if adjust.Accrual == nil || *adjust.Accrual < 0 || adjust.Reason == "" {
+			http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+			return
+		}
+
+		err := bh.repo.AdjustAccrual(chi.URLParam(req, numberURLParam), *adjust.Accrual, adjust.Reason, storage.AdjustedByAdmin)
+		if err != nil {
+			if errors.Is(err, storage.ErrOrderNotFound) {
+				http.Error(w, orderNotFound, http.StatusNotFound)
+				return
+			} else if errors.Is(err, storage.ErrOrderNotProcessed) {
+				http.Error(w, orderNotProcessed, http.StatusConflict)
+				return
+			} else if errors.Is(err, storage.ErrInvalidAccrual) {
+				http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+				return
+			}
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		logger.Logger.Err(err).Msg("")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, err = w.Write(buf)
+	if err != nil {
+		logger.Logger.Err(err).Msg("")
+	}
+}
+
+func (bh *BaseHandler) getWithdrawalsForReview() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		withdrawals, err := bh.repo.GetWithdrawalsForReview()
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		if withdrawals == nil {
+			http.Error(w, noWithdrawalsForReview, http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, withdrawals)
+	}
+}
+
+func writeReviewError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrWithdrawalNotFound) {
+		http.Error(w, withdrawalNotFound, http.StatusNotFound)
+	} else if errors.Is(err, storage.ErrWithdrawalNotInReview) {
+		http.Error(w, withdrawalNotInReview, http.StatusConflict)
+	} else {
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		logger.Logger.Err(err).Msg("")
+	}
+}
+
+func (bh *BaseHandler) approveWithdrawal() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		err := bh.repo.ApproveWithdrawal(chi.URLParam(req, numberURLParam))
+		if err != nil {
+			writeReviewError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (bh *BaseHandler) rejectWithdrawal() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		reject, err := decodeRefundRequest(req)
+		if err != nil {
+			http.Error(w, invalidJSON, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		err = bh.repo.RejectWithdrawal(chi.URLParam(req, numberURLParam), reject.Reason)
+		if err != nil {
+			writeReviewError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+const (
+	bearerPrefix        = "Bearer "
+	adminAPIDisabled    = "Admin API disabled"
+	invalidAdminToken   = "Invalid admin token"
+	errAdminAPIDisabled = "admin API is disabled: admin token is not configured"
+)
+
+func adminHandle(adminToken string) (ah func(http.Handler) http.Handler) {
+	ah = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if adminToken == "" {
+				http.Error(w, adminAPIDisabled, http.StatusForbidden)
+				logger.Logger.Err(errors.New(errAdminAPIDisabled)).Msg("")
+				return
+			}
+
+			authHeader := r.Header.Get("Authorization")
+			token := strings.TrimPrefix(authHeader, bearerPrefix)
+			if !strings.HasPrefix(authHeader, bearerPrefix) || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				http.Error(w, invalidAdminToken, http.StatusUnauthorized)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+	return
+}
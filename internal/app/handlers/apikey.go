@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	apiKeyHeader       = "X-API-Key"
+	noAPIKeys          = "No API keys"
+	apiKeyNotFound     = "API key not found"
+	invalidAPIKey      = "Invalid API key"
+	apiKeyIDParam      = "id"
+	apiKeyCtxKey   key = "apiKey"
+)
+
+type APIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// apiKeyHandle пропускает запросы с ключом API в заголовке X-API-Key, а без него проверяет сессию, как authHandle.
+func apiKeyHandle(secretKey string, repo storage.Repository) func(http.Handler) http.Handler {
+	session := authHandle(secretKey)
+	return func(h http.Handler) http.Handler {
+		withSession := session(h)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey := r.Header.Get(apiKeyHeader)
+			if apiKey == "" {
+				withSession.ServeHTTP(w, r)
+				return
+			}
+
+			userID, err := repo.AuthenticateAPIKey(apiKey)
+			if err != nil {
+				if errors.Is(err, storage.ErrInvalidAPIKey) {
+					http.Error(w, invalidAPIKey, http.StatusUnauthorized)
+					return
+				}
+				http.Error(w, internalServerError, http.StatusInternalServerError)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), userIDKey, userID)
+			ctx = context.WithValue(ctx, apiKeyCtxKey, apiKey)
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// getAPIKey возвращает ключ API, по которому прошёл запрос, или пустую строку для сессии.
+func getAPIKey(req *http.Request) string {
+	apiKey, _ := req.Context().Value(apiKeyCtxKey).(string)
+	return apiKey
+}
+
+func (bh *BaseHandler) createAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		var keyReq APIKeyRequest
+		if err := json.NewDecoder(req.Body).Decode(&keyReq); err != nil {
+			http.Error(w, invalidJSON, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+		if keyReq.Name == "" {
+			http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+			return
+		}
+
+		apiKey, err := bh.repo.CreateAPIKey(userID, keyReq.Name)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, apiKey)
+	}
+}
+
+func (bh *BaseHandler) getAPIKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		keys, err := bh.repo.GetAPIKeys(userID)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		if keys == nil {
+			http.Error(w, noAPIKeys, http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, keys)
+	}
+}
+
+func (bh *BaseHandler) revokeAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		keyID, err := strconv.ParseInt(chi.URLParam(req, apiKeyIDParam), 10, 64)
+		if err != nil {
+			http.Error(w, apiKeyNotFound, http.StatusNotFound)
+			return
+		}
+
+		err = bh.repo.RevokeAPIKey(userID, keyID)
+		if err != nil {
+			if errors.Is(err, storage.ErrAPIKeyNotFound) {
+				http.Error(w, apiKeyNotFound, http.StatusNotFound)
+				return
+			}
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -1,22 +1,35 @@
 package handlers
 
 import (
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/config"
 	"github.com/devkekops/gophermart/internal/app/storage"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
 type BaseHandler struct {
-	mux       *chi.Mux
-	secretKey string
-	repo      storage.Repository
+	mux             *chi.Mux
+	secretKey       string
+	adminToken      string
+	webhookSecret   string
+	bulkOrdersLimit int
+	idempotencyTTL  time.Duration
+	refundWindow    time.Duration
+	repo            storage.Repository
 }
 
-func NewBaseHandler(repo storage.Repository, secretKey string) *chi.Mux {
+func NewBaseHandler(repo storage.Repository, cfg *config.Config) *chi.Mux {
 	bh := &BaseHandler{
-		mux:       chi.NewMux(),
-		secretKey: secretKey,
-		repo:      repo,
+		mux:             chi.NewMux(),
+		secretKey:       cfg.SecretKey,
+		adminToken:      cfg.AdminToken,
+		webhookSecret:   cfg.AccrualWebhookSecret,
+		bulkOrdersLimit: cfg.BulkOrdersLimit,
+		idempotencyTTL:  time.Duration(cfg.IdempotencyTTL) * time.Second,
+		refundWindow:    time.Duration(cfg.RefundWindow) * time.Second,
+		repo:            repo,
 	}
 
 	bh.mux.Use(middleware.RequestID)
@@ -26,6 +39,9 @@ func NewBaseHandler(repo storage.Repository, secretKey string) *chi.Mux {
 
 	bh.mux.Use(middleware.Compress(5))
 	bh.mux.Use(gzipHandle)
+	bh.mux.Use(openAPIHandle(mustOpenAPIRouter()))
+
+	bh.mux.Get("/api/openapi.json", bh.openAPI())
 
 	bh.mux.Route("/api/user", func(r chi.Router) {
 		r.Post("/register", bh.register())
@@ -35,14 +51,87 @@ func NewBaseHandler(repo storage.Repository, secretKey string) *chi.Mux {
 			r.Use(authHandle(bh.secretKey))
 			r.Post("/orders", bh.loadOrder())
 			r.Get("/orders", bh.getOrders())
+			r.Post("/orders/batch", bh.loadOrders())
+			r.Get("/statement", bh.statement())
+			r.Get("/profile", bh.getProfile())
+			r.Get("/referrals", bh.getReferrals())
+			r.Get("/events", bh.events())
+
+			r.Route("/api-keys", func(r chi.Router) {
+				r.Get("/", bh.getAPIKeys())
+				r.Post("/", bh.createAPIKey())
+				r.Delete("/{id}", bh.revokeAPIKey())
+			})
 
 			r.Route("/balance", func(r chi.Router) {
 				r.Get("/", bh.getBalance())
-				r.Post("/withdraw", bh.withdraw())
+				r.With(idempotencyHandle(bh.repo, bh.idempotencyTTL)).Post("/withdraw", bh.withdraw())
+				r.With(idempotencyHandle(bh.repo, bh.idempotencyTTL)).Post("/withdraw/authorize", bh.authorizeWithdrawal())
+				r.Post("/withdraw/{number}/capture", bh.captureWithdrawal())
+				r.Post("/withdraw/{number}/void", bh.voidWithdrawal())
+				r.Get("/holds", bh.getHolds())
 				r.Get("/withdrawals", bh.withdrawals())
+				r.Post("/withdrawals/{number}/refund", bh.refundWithdrawal())
+				r.Get("/history", bh.getHistory())
+				r.With(idempotencyHandle(bh.repo, bh.idempotencyTTL)).Post("/transfer", bh.transfer())
+				r.Get("/transfers", bh.getTransfers())
+				r.Put("/transfers/settings", bh.setTransferSettings())
+				r.Post("/transfers/{id}/accept", bh.resolveTransfer(storage.TransferAccept))
+				r.Post("/transfers/{id}/decline", bh.resolveTransfer(storage.TransferDecline))
+				r.Post("/transfers/{id}/cancel", bh.resolveTransfer(storage.TransferCancel))
+				r.Post("/redeem", bh.redeemVoucher())
 			})
 		})
 	})
 
+	bh.mux.Route("/api/terminal", func(r chi.Router) {
+		r.Use(apiKeyHandle(bh.secretKey, bh.repo))
+		r.Get("/ws", bh.terminal())
+	})
+
+	bh.mux.Route("/internal/accrual", func(r chi.Router) {
+		r.Use(signatureHandle(bh.webhookSecret))
+		r.Post("/callback", bh.accrualCallback())
+	})
+
+	bh.mux.Route("/api/admin", func(r chi.Router) {
+		r.Use(adminHandle(bh.adminToken))
+
+		r.Route("/dead-letters", func(r chi.Router) {
+			r.Get("/", bh.getDeadLetters())
+			r.Post("/redrive", bh.redriveDeadLetters())
+			r.Get("/{number}", bh.getDeadLetter())
+			r.Post("/{number}/redrive", bh.redriveDeadLetter())
+		})
+
+		r.Post("/withdrawals/{number}/refund", bh.adminRefundWithdrawal())
+
+		r.Route("/withdrawals/review", func(r chi.Router) {
+			r.Get("/", bh.getWithdrawalsForReview())
+			r.Post("/{number}/approve", bh.approveWithdrawal())
+			r.Post("/{number}/reject", bh.rejectWithdrawal())
+		})
+
+		r.Route("/orders/review", func(r chi.Router) {
+			r.Get("/", bh.getOrdersForReview())
+			r.Post("/{number}/resume", bh.resumeOrder())
+		})
+		r.Post("/orders/{number}/adjust", bh.adjustAccrual())
+		r.Get("/orders/{number}/rules", bh.getRuleFirings())
+
+		r.Route("/rules", func(r chi.Router) {
+			r.Get("/", bh.getRules())
+			r.Post("/", bh.createRule())
+			r.Get("/{id}", bh.getRule())
+			r.Put("/{id}", bh.updateRule())
+			r.Delete("/{id}", bh.deleteRule())
+		})
+
+		r.Route("/vouchers", func(r chi.Router) {
+			r.Get("/", bh.getVoucherBatches())
+			r.Post("/", bh.mintVouchers())
+		})
+	})
+
 	return bh.mux
 }
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/luhn"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	tooManyOrders    = "Too many orders"
+	unsupportedMedia = "Unsupported content type"
+	errTooManyOrders = "too many orders in bulk upload"
+)
+
+var errUnsupportedMedia = errors.New("unsupported content type")
+
+// parseOrderNumbers читает номера заказов из тела запроса: построчный текст, JSON-массив или CSV
+// (номер в первой колонке, строка заголовка пропускается). Возвращает не больше limit+1 номеров,
+// чтобы вызывающий мог обнаружить превышение лимита, не читая тело целиком.
+func parseOrderNumbers(contentType string, body io.Reader, limit int) ([]string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	var numbers []string
+	switch mediaType {
+	case "application/json":
+		decoder := json.NewDecoder(body)
+		decoder.UseNumber()
+		if err := expectDelim(decoder, '['); err != nil {
+			return nil, err
+		}
+		for len(numbers) <= limit && decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch v := token.(type) {
+			case string:
+				numbers = append(numbers, strings.TrimSpace(v))
+			case json.Number:
+				numbers = append(numbers, v.String())
+			default:
+				return nil, fmt.Errorf("unexpected order number %v", token)
+			}
+		}
+		if len(numbers) <= limit {
+			if err := expectDelim(decoder, ']'); err != nil {
+				return nil, err
+			}
+		}
+
+	case "text/csv":
+		reader := csv.NewReader(body)
+		reader.FieldsPerRecord = -1
+		for len(numbers) <= limit {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			number := strings.TrimSpace(record[0])
+			if len(numbers) == 0 && number != "" && !isDigits(number) {
+				continue
+			}
+			if number != "" {
+				numbers = append(numbers, number)
+			}
+		}
+
+	case "text/plain":
+		scanner := bufio.NewScanner(body)
+		for len(numbers) <= limit && scanner.Scan() {
+			number := strings.TrimSpace(scanner.Text())
+			if number != "" {
+				numbers = append(numbers, number)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, errUnsupportedMedia
+	}
+
+	return numbers, nil
+}
+
+// expectDelim читает следующий токен JSON и проверяет, что это разделитель delim.
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func (bh *BaseHandler) loadOrders() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		numbers, err := parseOrderNumbers(req.Header.Get("Content-Type"), req.Body, bh.bulkOrdersLimit)
+		if err != nil {
+			if errors.Is(err, errUnsupportedMedia) {
+				http.Error(w, unsupportedMedia, http.StatusUnsupportedMediaType)
+				return
+			}
+			http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		if len(numbers) == 0 {
+			http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+			return
+		}
+		if len(numbers) > bh.bulkOrdersLimit {
+			http.Error(w, tooManyOrders, http.StatusRequestEntityTooLarge)
+			logger.Logger.Err(errors.New(errTooManyOrders)).Msg("")
+			return
+		}
+
+		results := make([]entity.OrderUploadResult, len(numbers))
+		var valid []string
+		var validIdx []int
+		for i, number := range numbers {
+			check, err := luhn.Check(number)
+			if err != nil || !check {
+				results[i] = entity.OrderUploadResult{OrderID: number, Result: storage.OrderInvalid}
+				continue
+			}
+			valid = append(valid, number)
+			validIdx = append(validIdx, i)
+		}
+
+		if len(valid) > 0 {
+			loaded, err := bh.repo.LoadOrders(valid, userID, req.Header.Get(merchantTagHeader))
+			if err != nil {
+				http.Error(w, internalServerError, http.StatusInternalServerError)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
+			for i, result := range loaded {
+				results[validIdx[i]] = result
+			}
+		}
+
+		writeJSON(w, http.StatusOK, results)
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOrderNumbers(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		limit       int
+		want        []string
+		wantErr     bool
+	}{
+		{"plain lines", "text/plain", "79927398713\n\n 4561261212345467 \n", 10, []string{"79927398713", "4561261212345467"}, false},
+		{"no content type", "", "79927398713", 10, []string{"79927398713"}, false},
+		{"json strings and numbers", "application/json; charset=utf-8", `[" 79927398713", 4561261212345467]`, 10, []string{"79927398713", "4561261212345467"}, false},
+		{"json empty array", "application/json", `[]`, 10, nil, false},
+		{"json object", "application/json", `{"order":"79927398713"}`, 10, nil, true},
+		{"json nested array", "application/json", `[["79927398713"]]`, 10, nil, true},
+		{"json unterminated", "application/json", `["79927398713"`, 10, nil, true},
+		{"json stops past limit", "application/json", `["1","2","3",` + strings.Repeat(`"4",`, 1000) + `garbage`, 2, []string{"1", "2", "3"}, false},
+		{"csv with header", "text/csv", "order,comment\n79927398713,first\n4561261212345467\n", 10, []string{"79927398713", "4561261212345467"}, false},
+		{"csv stops past limit", "text/csv", "1\n2\n3\n4\n", 2, []string{"1", "2", "3"}, false},
+		{"plain stops past limit", "text/plain", "1\n2\n3\n4\n", 2, []string{"1", "2", "3"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOrderNumbers(tt.contentType, strings.NewReader(tt.body), tt.limit)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOrderNumbersUnsupportedMedia(t *testing.T) {
+	_, err := parseOrderNumbers("application/xml", strings.NewReader("<orders/>"), 10)
+	if !errors.Is(err, errUnsupportedMedia) {
+		t.Errorf("err = %v, want %v", err, errUnsupportedMedia)
+	}
+}
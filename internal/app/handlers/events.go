@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/events"
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+const (
+	streamingUnsupported = "Streaming unsupported"
+	keepAliveInterval    = 15 * time.Second
+)
+
+func writeEvent(w http.ResponseWriter, kind string, data []byte) error {
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", kind, data)
+	return err
+}
+
+// events отдаёт поток Server-Sent Events: сначала текущий баланс, затем изменения заказов и баланса пользователя.
+// Поток закрывается, если клиент не успевает читать события; после переподключения клиент снова получит баланс.
+func (bh *BaseHandler) events() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, streamingUnsupported, http.StatusInternalServerError)
+			return
+		}
+
+		// подписка до чтения баланса, чтобы не потерять изменения между ними
+		ch, unsubscribe := bh.repo.Subscribe(userID)
+		defer unsubscribe()
+
+		balance, err := bh.repo.GetBalance(userID)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+		snapshot, err := json.Marshal(balance)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if err := writeEvent(w, events.BalanceEvent, snapshot); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		ticker := time.NewTicker(keepAliveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-ticker.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			case ev, ok := <-ch:
+				if !ok {
+					return
+				}
+				if err := writeEvent(w, ev.Type, ev.Data); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -1,39 +1,46 @@
 package handlers
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
 	"database/sql"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
 
+	"github.com/devkekops/gophermart/internal/app/auth"
 	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/luhn"
 	"github.com/devkekops/gophermart/internal/app/storage"
 )
 
 const (
 	invalidJSON            = "Invalid JSON"
 	loginAlreadyInUse      = "Login already in use"
+	unknownReferralCode    = "Unknown referral code"
 	internalServerError    = "Internal Server Error"
 	invalidCredentials     = "Invalid credentials"
 	invalidRequestFormat   = "Invalid request format"
 	invalidOrderNumber     = "Invalid order number"
+	invalidSum             = "Sum must be positive"
 	noOrders               = "No orders"
 	noWithdrawals          = "No withdrawals"
 	insufficientFunds      = "Insuficient funds"
+	withdrawalExists       = "Order already paid with points"
+	withdrawalLimit        = "Withdrawal limit exceeded"
+	accountTooNew          = "Account is too new to withdraw"
 	invalidUserIDInContext = "invalid userID in context"
+	merchantTagHeader      = "X-Merchant-Tag"
 )
 
 type Credentials struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login        string `json:"login"`
+	Password     string `json:"password"`
+	ReferralCode string `json:"referral_code,omitempty"`
 }
 
 type Withdrawal struct {
@@ -41,59 +48,63 @@ type Withdrawal struct {
 	Sum   float64 `json:"sum"`
 }
 
-func getPasswordHash(password string) string {
-	h := sha256.New()
-	h.Write([]byte(password))
-	hash := h.Sum(nil)
-	passwordHash := hex.EncodeToString(hash)
-	return passwordHash
+type WithdrawalStatus struct {
+	Order  string `json:"order"`
+	Status string `json:"status"`
 }
 
-func checkLuhn(orderID string) (bool, error) {
-	number, err := strconv.Atoi(orderID)
+func getUserID(req *http.Request) (string, error) {
+	userIDctx := req.Context().Value(userIDKey)
+	userID, ok := userIDctx.(string)
+	if !ok {
+		return "", errors.New(invalidUserIDInContext)
+	}
+	return userID, nil
+}
+
+// validateOrderNumber проверяет номер заказа и возвращает код и текст ответа при ошибке, 0 - номер корректен.
+// Общая для HTTP и WebSocket API.
+func validateOrderNumber(orderID string) (int, string) {
+	check, err := luhn.Check(orderID)
 	if err != nil {
-		return false, err
+		return http.StatusBadRequest, invalidRequestFormat
 	}
-	payload := number / 10
-
-	var luhn int
-	for i := 0; payload > 0; i++ {
-		cur := payload % 10
-		if i%2 == 0 { // even
-			cur = cur * 2
-			if cur > 9 {
-				cur = cur%10 + cur/10
-			}
-		}
-		luhn += cur
-		payload = payload / 10
+	if !check {
+		return http.StatusUnprocessableEntity, invalidOrderNumber
 	}
-	checksum := luhn % 10
-
-	return (number%10+checksum)%10 == 0, nil
+	return 0, ""
 }
 
-func createSession(userID string, secretKey string) string {
-	userIDBytes := []byte(userID)
-
-	key := sha256.Sum256([]byte(secretKey))
-	h := hmac.New(sha256.New, key[:])
-	h.Write(userIDBytes)
-	dst := h.Sum(nil)
-
-	sessionBytes := append(userIDBytes[:], dst[:]...)
-	session := hex.EncodeToString(sessionBytes)
+// loadOrderResult переводит результат LoadOrder в код и текст ответа; пустой текст - ответ без тела.
+func loadOrderResult(err error) (int, string) {
+	if err == nil {
+		return http.StatusAccepted, ""
+	}
 
-	return session
+	logger.Logger.Err(err).Msg("")
+	if errors.Is(err, storage.ErrOrderExistsForCurrentUser) {
+		return http.StatusOK, ""
+	} else if errors.Is(err, storage.ErrOrderExistsForOtherUser) {
+		return http.StatusConflict, ""
+	}
+	return http.StatusBadRequest, invalidRequestFormat
 }
 
-func getUserID(req *http.Request) (string, error) {
-	userIDctx := req.Context().Value(userIDKey)
-	userID, ok := userIDctx.(string)
-	if !ok {
-		return "", errors.New(invalidUserIDInContext)
+// withdrawResult переводит ошибку списания в код и текст ответа.
+func withdrawResult(err error) (int, string) {
+	if errors.Is(err, storage.ErrInsufficientFunds) {
+		return http.StatusPaymentRequired, insufficientFunds
+	} else if errors.Is(err, storage.ErrWithdrawalExists) {
+		return http.StatusConflict, withdrawalExists
+	} else if errors.Is(err, storage.ErrWithdrawalLimitExceeded) {
+		return http.StatusForbidden, withdrawalLimit
+	} else if errors.Is(err, storage.ErrAccountTooNew) {
+		return http.StatusForbidden, accountTooNew
+	} else if errors.Is(err, storage.ErrInvalidSum) {
+		return http.StatusBadRequest, invalidSum
 	}
-	return userID, nil
+	logger.Logger.Err(err).Msg("")
+	return http.StatusInternalServerError, internalServerError
 }
 
 func (bh *BaseHandler) register() http.HandlerFunc {
@@ -105,24 +116,26 @@ func (bh *BaseHandler) register() http.HandlerFunc {
 			return
 		}
 
-		passwordHash := getPasswordHash(creds.Password)
+		passwordHash := auth.PasswordHash(creds.Password)
 
-		userID, err := bh.repo.CreateUser(creds.Login, passwordHash)
+		userID, err := bh.repo.CreateUser(creds.Login, passwordHash, creds.ReferralCode)
 		if err != nil {
+			if errors.Is(err, storage.ErrUnknownReferralCode) {
+				http.Error(w, unknownReferralCode, http.StatusBadRequest)
+				return
+			}
 			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) {
-				if pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
-					http.Error(w, loginAlreadyInUse, http.StatusConflict)
-					logger.Logger.Err(err).Msg("")
-					return
-				}
-				http.Error(w, internalServerError, http.StatusInternalServerError)
+			if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
+				http.Error(w, loginAlreadyInUse, http.StatusConflict)
 				logger.Logger.Err(err).Msg("")
 				return
 			}
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
 		}
 
-		session := createSession(userID, bh.secretKey)
+		session := auth.CreateSession(userID, bh.secretKey)
 		cookie := &http.Cookie{
 			Name:  cookieName,
 			Value: session,
@@ -143,7 +156,7 @@ func (bh *BaseHandler) login() http.HandlerFunc {
 			return
 		}
 
-		passwordHash := getPasswordHash(creds.Password)
+		passwordHash := auth.PasswordHash(creds.Password)
 
 		userID, err := bh.repo.AuthUser(creds.Login, passwordHash)
 		if err != nil {
@@ -157,7 +170,7 @@ func (bh *BaseHandler) login() http.HandlerFunc {
 			return
 		}
 
-		session := createSession(userID, bh.secretKey)
+		session := auth.CreateSession(userID, bh.secretKey)
 
 		cookie := &http.Cookie{
 			Name:  cookieName,
@@ -187,36 +200,19 @@ func (bh *BaseHandler) loadOrder() http.HandlerFunc {
 		}
 		orderID := string(b)
 
-		check, err := checkLuhn(orderID)
-		if err != nil {
-			http.Error(w, invalidRequestFormat, http.StatusBadRequest)
-			logger.Logger.Err(err).Msg("")
+		if code, msg := validateOrderNumber(orderID); code != 0 {
+			http.Error(w, msg, code)
 			return
 		}
 
-		if !check {
-			http.Error(w, invalidOrderNumber, http.StatusUnprocessableEntity)
+		err = bh.repo.LoadOrder(orderID, userID, req.Header.Get(merchantTagHeader))
+		code, msg := loadOrderResult(err)
+		if msg != "" {
+			http.Error(w, msg, code)
 			return
 		}
 
-		err = bh.repo.LoadOrder(orderID, userID)
-		if err != nil {
-			if errors.Is(err, storage.ErrOrderExistsForCurrentUser) {
-				w.WriteHeader(http.StatusOK)
-				logger.Logger.Err(err).Msg("")
-				return
-			} else if errors.Is(err, storage.ErrOrderExistsForOtherUser) {
-				w.WriteHeader(http.StatusConflict)
-				logger.Logger.Err(err).Msg("")
-				return
-			} else {
-				http.Error(w, invalidRequestFormat, http.StatusBadRequest)
-				logger.Logger.Err(err).Msg("")
-				return
-			}
-		}
-
-		w.WriteHeader(http.StatusAccepted)
+		w.WriteHeader(code)
 	}
 }
 
@@ -229,8 +225,20 @@ func (bh *BaseHandler) getOrders() http.HandlerFunc {
 			return
 		}
 
-		orders, err := bh.repo.GetOrders(userID)
+		q, err := parseOrdersQuery(req.URL.Query())
 		if err != nil {
+			http.Error(w, invalidQuery, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		orders, next, err := bh.repo.GetOrders(userID, q)
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidCursor) {
+				http.Error(w, invalidQuery, http.StatusBadRequest)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
 			http.Error(w, internalServerError, http.StatusInternalServerError)
 			logger.Logger.Err(err).Msg("")
 			return
@@ -241,6 +249,8 @@ func (bh *BaseHandler) getOrders() http.HandlerFunc {
 			return
 		}
 
+		setNextPage(w, req, next)
+
 		buf, err := json.Marshal(orders)
 		if err != nil {
 			http.Error(w, internalServerError, http.StatusInternalServerError)
@@ -289,6 +299,49 @@ func (bh *BaseHandler) getBalance() http.HandlerFunc {
 	}
 }
 
+// validateWithdrawal проверяет номер заказа и сумму списания; 0 означает, что всё в порядке.
+func validateWithdrawal(withdrawal Withdrawal) (int, string) {
+	if code, msg := validateOrderNumber(withdrawal.Order); code != 0 {
+		return code, msg
+	}
+	if !(withdrawal.Sum > 0) || math.IsInf(withdrawal.Sum, 0) {
+		return http.StatusBadRequest, invalidSum
+	}
+	return 0, ""
+}
+
+// decodeWithdrawal читает списание и проверяет номер заказа и сумму. При ошибке ответ уже отправлен.
+func decodeWithdrawal(w http.ResponseWriter, req *http.Request) (Withdrawal, bool) {
+	var withdrawal Withdrawal
+	if err := json.NewDecoder(req.Body).Decode(&withdrawal); err != nil {
+		http.Error(w, invalidJSON, http.StatusBadRequest)
+		logger.Logger.Err(err).Msg("")
+		return withdrawal, false
+	}
+
+	if code, msg := validateWithdrawal(withdrawal); code != 0 {
+		http.Error(w, msg, code)
+		return withdrawal, false
+	}
+
+	return withdrawal, true
+}
+
+func writeWithdrawError(w http.ResponseWriter, err error) {
+	code, msg := withdrawResult(err)
+	http.Error(w, msg, code)
+}
+
+// writeWithdrawStatus отвечает 202 со статусом, если списание ждёт ручной проверки, иначе 200.
+func writeWithdrawStatus(w http.ResponseWriter, orderID string, status string) {
+	if status == storage.WithdrawalReview {
+		writeJSON(w, http.StatusAccepted, WithdrawalStatus{Order: orderID, Status: status})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 func (bh *BaseHandler) withdraw() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		userID, err := getUserID(req)
@@ -298,40 +351,18 @@ func (bh *BaseHandler) withdraw() http.HandlerFunc {
 			return
 		}
 
-		var withdrawal Withdrawal
-		if err := json.NewDecoder(req.Body).Decode(&withdrawal); err != nil {
-			http.Error(w, invalidJSON, http.StatusBadRequest)
-			logger.Logger.Err(err).Msg("")
+		withdrawal, ok := decodeWithdrawal(w, req)
+		if !ok {
 			return
 		}
 
-		check, err := checkLuhn(withdrawal.Order)
+		status, err := bh.repo.Withdraw(withdrawal.Order, userID, withdrawal.Sum)
 		if err != nil {
-			http.Error(w, invalidRequestFormat, http.StatusBadRequest)
-			logger.Logger.Err(err).Msg("")
+			writeWithdrawError(w, err)
 			return
 		}
 
-		if !check {
-			http.Error(w, invalidOrderNumber, http.StatusUnprocessableEntity)
-			logger.Logger.Err(err).Msg("")
-			return
-		}
-
-		err = bh.repo.Withdraw(withdrawal.Order, userID, withdrawal.Sum)
-		if err != nil {
-			if errors.Is(err, storage.ErrInsufficientFunds) {
-				http.Error(w, insufficientFunds, http.StatusPaymentRequired)
-				return
-			} else {
-				http.Error(w, internalServerError, http.StatusBadRequest)
-				logger.Logger.Err(err).Msg("")
-				return
-			}
-		}
-
-		w.WriteHeader(http.StatusOK)
-
+		writeWithdrawStatus(w, withdrawal.Order, status)
 	}
 }
 
@@ -344,19 +375,36 @@ func (bh *BaseHandler) withdrawals() http.HandlerFunc {
 			return
 		}
 
-		withdrawals, err := bh.repo.GetWithdrawals(userID)
+		q, err := parseWithdrawalsQuery(req.URL.Query())
+		if err != nil {
+			http.Error(w, invalidQuery, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		page, err := bh.repo.GetWithdrawals(userID, q)
 		if err != nil {
+			if errors.Is(err, storage.ErrInvalidCursor) {
+				http.Error(w, invalidQuery, http.StatusBadRequest)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
 			http.Error(w, internalServerError, http.StatusInternalServerError)
 			logger.Logger.Err(err).Msg("")
 			return
 		}
 
-		if withdrawals == nil {
+		w.Header().Set(totalCountHeader, strconv.Itoa(page.Totals.Count))
+		w.Header().Set(totalSumHeader, strconv.FormatFloat(page.Totals.Sum, 'f', 2, 64))
+
+		if page.Withdrawals == nil {
 			http.Error(w, noWithdrawals, http.StatusNoContent)
 			return
 		}
 
-		buf, err := json.Marshal(withdrawals)
+		setNextPage(w, req, page.Next)
+
+		buf, err := json.Marshal(page.Withdrawals)
 		if err != nil {
 			http.Error(w, internalServerError, http.StatusInternalServerError)
 			logger.Logger.Err(err).Msg("")
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	noHistory           = "No history"
+	withdrawalNotFound  = "Withdrawal not found"
+	alreadyRefunded     = "Withdrawal already refunded"
+	refundWindowExpired = "Refund window expired"
+	withdrawalInReview  = "Withdrawal is under review"
+)
+
+type RefundRequest struct {
+	Reason string `json:"reason"`
+}
+
+func parseHistoryQuery(values url.Values) (storage.HistoryQuery, error) {
+	var q storage.HistoryQuery
+	var err error
+
+	if q.Kinds, err = parseList(values, "type", storage.HistoryAccrual, storage.HistoryWithdrawal, storage.HistoryRefund,
+		storage.HistoryAdjustment, storage.HistoryExpiry, storage.HistoryTransferOut, storage.HistoryTransferIn, storage.HistoryTransferReturn,
+		storage.HistoryBonus, storage.HistoryReferral, storage.HistoryVoucher,
+		storage.HistoryHold, storage.HistoryHoldRelease); err != nil {
+		return q, err
+	}
+	if q.From, err = parseTime(values, "from"); err != nil {
+		return q, err
+	}
+	if q.To, err = parseTime(values, "to"); err != nil {
+		return q, err
+	}
+	if q.Desc, err = parseSortDesc(values); err != nil {
+		return q, err
+	}
+	if q.Limit, err = parseLimit(values); err != nil {
+		return q, err
+	}
+	q.Cursor = values.Get("cursor")
+
+	return q, nil
+}
+
+// decodeRefundRequest допускает пустое тело: причина возврата необязательна.
+func decodeRefundRequest(req *http.Request) (RefundRequest, error) {
+	var refund RefundRequest
+	err := json.NewDecoder(req.Body).Decode(&refund)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return refund, err
+	}
+	return refund, nil
+}
+
+func writeRefundError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrWithdrawalNotFound) {
+		http.Error(w, withdrawalNotFound, http.StatusNotFound)
+	} else if errors.Is(err, storage.ErrAlreadyRefunded) {
+		http.Error(w, alreadyRefunded, http.StatusConflict)
+	} else if errors.Is(err, storage.ErrRefundWindowExpired) {
+		http.Error(w, refundWindowExpired, http.StatusForbidden)
+	} else if errors.Is(err, storage.ErrWithdrawalInReview) {
+		http.Error(w, withdrawalInReview, http.StatusConflict)
+	} else {
+		http.Error(w, internalServerError, http.StatusInternalServerError)
+		logger.Logger.Err(err).Msg("")
+	}
+}
+
+func (bh *BaseHandler) getHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		q, err := parseHistoryQuery(req.URL.Query())
+		if err != nil {
+			http.Error(w, invalidQuery, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		entries, next, err := bh.repo.GetHistory(userID, q)
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidCursor) {
+				http.Error(w, invalidQuery, http.StatusBadRequest)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		if entries == nil {
+			http.Error(w, noHistory, http.StatusNoContent)
+			return
+		}
+
+		setNextPage(w, req, next)
+		writeJSON(w, http.StatusOK, entries)
+	}
+}
+
+func (bh *BaseHandler) refundWithdrawal() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		refund, err := decodeRefundRequest(req)
+		if err != nil {
+			http.Error(w, invalidJSON, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		err = bh.repo.RefundWithdrawal(chi.URLParam(req, numberURLParam), userID, refund.Reason, bh.refundWindow)
+		if err != nil {
+			writeRefundError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	noHolds            = "No holds"
+	holdNotFound       = "Hold not found"
+	holdExpired        = "Hold expired"
+	captureExceedsHold = "Capture sum exceeds hold"
+)
+
+// CaptureRequest - сумма списания из блокировки; без тела или с нулевой суммой списывается вся блокировка.
+type CaptureRequest struct {
+	Sum float64 `json:"sum"`
+}
+
+func writeHoldError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrHoldNotFound) {
+		http.Error(w, holdNotFound, http.StatusNotFound)
+	} else if errors.Is(err, storage.ErrHoldExpired) {
+		http.Error(w, holdExpired, http.StatusGone)
+	} else if errors.Is(err, storage.ErrCaptureExceedsHold) {
+		http.Error(w, captureExceedsHold, http.StatusBadRequest)
+	} else {
+		writeWithdrawError(w, err)
+	}
+}
+
+func (bh *BaseHandler) authorizeWithdrawal() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		withdrawal, ok := decodeWithdrawal(w, req)
+		if !ok {
+			return
+		}
+
+		hold, err := bh.repo.AuthorizeWithdrawal(withdrawal.Order, userID, withdrawal.Sum)
+		if err != nil {
+			writeWithdrawError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, hold)
+	}
+}
+
+func (bh *BaseHandler) captureWithdrawal() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		var capture CaptureRequest
+		if err := json.NewDecoder(req.Body).Decode(&capture); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, invalidJSON, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+		if capture.Sum < 0 {
+			http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+			return
+		}
+
+		orderID := chi.URLParam(req, numberURLParam)
+		status, err := bh.repo.CaptureWithdrawal(orderID, userID, capture.Sum)
+		if err != nil {
+			writeHoldError(w, err)
+			return
+		}
+
+		writeWithdrawStatus(w, orderID, status)
+	}
+}
+
+func (bh *BaseHandler) voidWithdrawal() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		err = bh.repo.VoidWithdrawal(chi.URLParam(req, numberURLParam), userID)
+		if err != nil {
+			writeHoldError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (bh *BaseHandler) getHolds() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		holds, err := bh.repo.GetHolds(userID)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		if holds == nil {
+			http.Error(w, noHolds, http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, holds)
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	invalidIdempotencyKey    = "Invalid Idempotency-Key"
+	idempotencyKeyInUse      = "Request with this Idempotency-Key is in progress"
+	idempotencyKeyMismatch   = "Idempotency-Key reused with a different request"
+)
+
+type recordingWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(statusCode int) {
+	rw.statusCode = statusCode
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.statusCode == 0 {
+		rw.statusCode = http.StatusOK
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+// idempotencyHandle повторяет сохранённый ответ на запрос с уже использованным Idempotency-Key
+// вместо повторного выполнения. Ключи хранятся отдельно для каждого пользователя в течение ttl.
+// Ответы 5xx не сохраняются, чтобы клиент мог повторить запрос с тем же ключом.
+func idempotencyHandle(repo storage.Repository, ttl time.Duration) (ih func(http.Handler) http.Handler) {
+	ih = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				http.Error(w, invalidIdempotencyKey, http.StatusBadRequest)
+				return
+			}
+
+			userID, err := getUserID(r)
+			if err != nil {
+				http.Error(w, internalServerError, http.StatusInternalServerError)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+			requestHash := hex.EncodeToString(hash[:])
+
+			stored, reserved, err := repo.ReserveIdempotencyKey(userID, key, requestHash, ttl)
+			if err != nil {
+				http.Error(w, internalServerError, http.StatusInternalServerError)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
+
+			if !reserved {
+				switch {
+				case stored.RequestHash != requestHash:
+					http.Error(w, idempotencyKeyMismatch, http.StatusUnprocessableEntity)
+				case stored.StatusCode == 0:
+					http.Error(w, idempotencyKeyInUse, http.StatusConflict)
+				default:
+					if stored.ContentType != "" {
+						w.Header().Set("Content-Type", stored.ContentType)
+					}
+					w.Header().Set(idempotentReplayedHeader, "true")
+					w.WriteHeader(stored.StatusCode)
+					if _, err := w.Write(stored.Body); err != nil {
+						logger.Logger.Err(err).Msg("")
+					}
+				}
+				return
+			}
+
+			rw := &recordingWriter{ResponseWriter: w}
+			h.ServeHTTP(rw, r)
+
+			if rw.statusCode == 0 || rw.statusCode >= http.StatusInternalServerError {
+				if err := repo.ReleaseIdempotencyKey(userID, key); err != nil {
+					logger.Logger.Err(err).Msg("")
+				}
+				return
+			}
+
+			resp := entity.IdempotentResponse{
+				RequestHash: requestHash,
+				StatusCode:  rw.statusCode,
+				ContentType: rw.Header().Get("Content-Type"),
+				Body:        rw.body.Bytes(),
+			}
+			if err := repo.SaveIdempotentResponse(userID, key, resp); err != nil {
+				logger.Logger.Err(err).Msg("")
+			}
+		})
+	}
+	return
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// loadOpenAPI разбирает и проверяет встроенную спецификацию.
+func loadOpenAPI() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// maxRequestBody ограничивает тело любого запроса после распаковки gzip.
+const maxRequestBody = 1 << 20
+
+const (
+	requestTooLarge        = "Request body too large"
+	unsupportedContentType = "Unsupported Content-Type"
+)
+
+// openAPIHandle проверяет параметры и тело запроса по спецификации до обработчика.
+// Аутентификацию по-прежнему выполняют middleware групп маршрутов, запросы вне спецификации
+// пропускаются к chi. Непустое тело принимается только с Content-Type, описанным в спецификации.
+func openAPIHandle(router routers.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxRequestBody {
+				http.Error(w, requestTooLarge, http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+
+			route, pathParams, err := router.FindRoute(r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if body := route.Operation.RequestBody; body != nil && body.Value != nil && r.ContentLength != 0 {
+				if body.Value.Content.Get(r.Header.Get("Content-Type")) == nil {
+					http.Error(w, unsupportedContentType, http.StatusUnsupportedMediaType)
+					return
+				}
+			}
+
+			options := &openapi3filter.Options{
+				AuthenticationFunc:         openapi3filter.NoopAuthenticationFunc,
+				ExcludeReadOnlyValidations: true,
+				SkipSettingDefaults:        true,
+			}
+			options.WithCustomSchemaErrorFunc(schemaErrorMessage)
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options,
+			}
+			if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+				var reqErr *openapi3filter.RequestError
+				if errors.As(err, &reqErr) {
+					http.Error(w, invalidRequestFormat+": "+reqErr.Error(), http.StatusBadRequest)
+					return
+				}
+				http.Error(w, internalServerError, http.StatusInternalServerError)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// schemaErrorMessage оставляет от ошибки схемы путь и причину, без схемы и присланного значения.
+func schemaErrorMessage(err *openapi3.SchemaError) string {
+	return "/" + strings.Join(err.JSONPointer(), "/") + ": " + err.Reason
+}
+
+func (bh *BaseHandler) openAPI() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write(openAPISpec)
+		if err != nil {
+			logger.Logger.Err(err).Msg("")
+		}
+	}
+}
+
+// mustOpenAPIRouter строит маршрутизатор по встроенной спецификации. Спецификация - часть бинарника,
+// поэтому ошибка здесь означает ошибку сборки и проверяется тестом.
+func mustOpenAPIRouter() routers.Router {
+	doc, err := loadOpenAPI()
+	if err != nil {
+		panic(err)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		panic(err)
+	}
+	return router
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/devkekops/gophermart/internal/app/config"
+)
+
+// TestRoutesMatchSpec падает, если маршрут добавлен в NewBaseHandler без описания в openapi.json или наоборот.
+func TestRoutesMatchSpec(t *testing.T) {
+	mux := NewBaseHandler(nil, &config.Config{})
+
+	routes := map[string]bool{}
+	err := chi.Walk(mux, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if len(route) > 1 {
+			route = strings.TrimSuffix(route, "/")
+		}
+		routes[method+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := loadOpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method := range item.Operations() {
+			spec[method+" "+path] = true
+		}
+	}
+
+	var missing, extra []string
+	for route := range routes {
+		if !spec[route] {
+			missing = append(missing, route)
+		}
+	}
+	for route := range spec {
+		if !routes[route] {
+			extra = append(extra, route)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+
+	for _, route := range missing {
+		t.Errorf("route %s is not described in openapi.json", route)
+	}
+	for _, route := range extra {
+		t.Errorf("openapi.json describes %s, but it is not registered", route)
+	}
+}
+
+func TestOpenAPIValidation(t *testing.T) {
+	mux := NewBaseHandler(nil, &config.Config{})
+
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"spec", http.MethodGet, "/api/openapi.json", "", "", http.StatusOK},
+		{"missing password", http.MethodPost, "/api/user/register", "application/json", `{"login":"user"}`, http.StatusBadRequest},
+		{"wrong login type", http.MethodPost, "/api/user/login", "application/json", `{"login":1,"password":"secret"}`, http.StatusBadRequest},
+		{"invalid limit", http.MethodGet, "/api/user/orders?limit=0", "", "", http.StatusBadRequest},
+		{"valid query reaches auth", http.MethodGet, "/api/user/orders?limit=10", "", "", http.StatusUnauthorized},
+		{"zero withdrawal", http.MethodPost, "/api/user/balance/withdraw", "application/json", `{"order":"79927398713","sum":0}`, http.StatusBadRequest},
+		{"negative withdrawal", http.MethodPost, "/api/user/balance/withdraw", "application/json", `{"order":"79927398713","sum":-5}`, http.StatusBadRequest},
+		{"body without content type", http.MethodPost, "/api/user/login", "", `{"login":1}`, http.StatusUnsupportedMediaType},
+		{"wrong content type", http.MethodPost, "/api/user/login", "text/plain", `{"login":1}`, http.StatusUnsupportedMediaType},
+		{"missing required body", http.MethodPost, "/api/user/login", "", "", http.StatusBadRequest},
+		{"body too large", http.MethodPost, "/api/user/login", "application/json", strings.Repeat(" ", maxRequestBody+1), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	maxPageLimit     = 1000
+	dateLayout       = "2006-01-02"
+	nextCursorHeader = "X-Next-Cursor"
+	totalCountHeader = "X-Total-Count"
+	totalSumHeader   = "X-Total-Sum"
+	invalidQuery     = "Invalid query parameters"
+)
+
+var errInvalidQueryParam = errors.New("invalid query parameter")
+
+func parseLimit(values url.Values) (int, error) {
+	raw := values.Get("limit")
+	if raw == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("%w: limit=%q", errInvalidQueryParam, raw)
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return limit, nil
+}
+
+// parseTime принимает RFC3339 или дату YYYY-MM-DD (начало суток UTC).
+func parseTime(values url.Values, name string) (time.Time, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(dateLayout, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s=%q", errInvalidQueryParam, name, raw)
+	}
+	return t, nil
+}
+
+func parseSortDesc(values url.Values) (bool, error) {
+	switch strings.ToLower(values.Get("sort")) {
+	case "", "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: sort=%q", errInvalidQueryParam, values.Get("sort"))
+	}
+}
+
+func parseList(values url.Values, name string, allowed ...string) ([]string, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+
+	var list []string
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.ToUpper(strings.TrimSpace(item))
+		valid := false
+		for _, a := range allowed {
+			if item == a {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("%w: %s=%q", errInvalidQueryParam, name, raw)
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+// setNextPage отдаёт курсор следующей страницы в заголовках, не меняя формат тела ответа.
+func setNextPage(w http.ResponseWriter, req *http.Request, next string) {
+	if next == "" {
+		return
+	}
+	values := req.URL.Query()
+	values.Set("cursor", next)
+	nextURL := url.URL{Path: req.URL.Path, RawQuery: values.Encode()}
+
+	w.Header().Set(nextCursorHeader, next)
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.String()))
+}
+
+func parseOrdersQuery(values url.Values) (storage.OrdersQuery, error) {
+	var q storage.OrdersQuery
+	var err error
+
+	if q.Statuses, err = parseList(values, "status", storage.NEW, storage.REGISTERED, storage.PROCESSING, storage.PROCESSED, storage.INVALID); err != nil {
+		return q, err
+	}
+	if q.From, err = parseTime(values, "from"); err != nil {
+		return q, err
+	}
+	if q.To, err = parseTime(values, "to"); err != nil {
+		return q, err
+	}
+	if q.Desc, err = parseSortDesc(values); err != nil {
+		return q, err
+	}
+	if q.Limit, err = parseLimit(values); err != nil {
+		return q, err
+	}
+	q.Cursor = values.Get("cursor")
+
+	return q, nil
+}
+
+func parseSum(values url.Values, name string) (float64, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+	sum, err := strconv.ParseFloat(raw, 64)
+	if err != nil || sum < 0 {
+		return 0, fmt.Errorf("%w: %s=%q", errInvalidQueryParam, name, raw)
+	}
+	return sum, nil
+}
+
+func parseWithdrawalsQuery(values url.Values) (storage.WithdrawalsQuery, error) {
+	var q storage.WithdrawalsQuery
+	var err error
+
+	if q.From, err = parseTime(values, "from"); err != nil {
+		return q, err
+	}
+	if q.To, err = parseTime(values, "to"); err != nil {
+		return q, err
+	}
+	if q.MinSum, err = parseSum(values, "min_sum"); err != nil {
+		return q, err
+	}
+	if q.MaxSum, err = parseSum(values, "max_sum"); err != nil {
+		return q, err
+	}
+	if q.Desc, err = parseSortDesc(values); err != nil {
+		return q, err
+	}
+	if q.Limit, err = parseLimit(values); err != nil {
+		return q, err
+	}
+	q.Cursor = values.Get("cursor")
+
+	return q, nil
+}
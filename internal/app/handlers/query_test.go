@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+func TestParseList(t *testing.T) {
+	allowed := []string{storage.NEW, storage.PROCESSED, storage.INVALID}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    []string
+		wantErr bool
+	}{
+		{"absent", "", nil, false},
+		{"single", "status=NEW", []string{"NEW"}, false},
+		{"case and spaces", "status=processed,%20invalid", []string{"PROCESSED", "INVALID"}, false},
+		{"unknown value", "status=NEW,DONE", nil, true},
+		{"empty item", "status=NEW,", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseList(values, "status", allowed...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseList() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errInvalidQueryParam) {
+				t.Errorf("parseList() error = %v, want %v", err, errInvalidQueryParam)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOrdersQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    storage.OrdersQuery
+		wantErr bool
+	}{
+		{"empty", "", storage.OrdersQuery{}, false},
+		{"all parameters", "status=NEW&from=2024-01-01&to=2024-02-01T10:00:00Z&sort=DESC&limit=20&cursor=abc", storage.OrdersQuery{
+			Statuses: []string{"NEW"},
+			From:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:       time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC),
+			Desc:     true,
+			Limit:    20,
+			Cursor:   "abc",
+		}, false},
+		{"limit capped", "limit=5000", storage.OrdersQuery{Limit: maxPageLimit}, false},
+		{"zero limit", "limit=0", storage.OrdersQuery{}, true},
+		{"bad sort", "sort=up", storage.OrdersQuery{}, true},
+		{"bad date", "from=01.01.2024", storage.OrdersQuery{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseOrdersQuery(values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOrdersQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOrdersQuery() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/rules"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	noRules          = "No rules"
+	noRuleFirings    = "No rules fired for order"
+	ruleNotFound     = "Rule not found"
+	ruleIDURLParam   = "id"
+	invalidRuleField = "Invalid rule: "
+)
+
+func parseRuleID(req *http.Request) (int64, bool) {
+	ruleID, err := strconv.ParseInt(chi.URLParam(req, ruleIDURLParam), 10, 64)
+	return ruleID, err == nil
+}
+
+// decodeRule читает и проверяет правило. При ошибке ответ уже отправлен.
+func decodeRule(w http.ResponseWriter, req *http.Request) (rules.Rule, bool) {
+	var rule rules.Rule
+	if err := json.NewDecoder(req.Body).Decode(&rule); err != nil {
+		http.Error(w, invalidJSON, http.StatusBadRequest)
+		logger.Logger.Err(err).Msg("")
+		return rule, false
+	}
+	if err := rule.Validate(); err != nil {
+		http.Error(w, invalidRuleField+err.Error(), http.StatusBadRequest)
+		return rule, false
+	}
+	return rule, true
+}
+
+func writeRuleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrRuleNotFound) {
+		http.Error(w, ruleNotFound, http.StatusNotFound)
+		return
+	}
+	http.Error(w, internalServerError, http.StatusInternalServerError)
+	logger.Logger.Err(err).Msg("")
+}
+
+func (bh *BaseHandler) getRules() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ruleList, err := bh.repo.GetRules()
+		if err != nil {
+			writeRuleError(w, err)
+			return
+		}
+
+		if ruleList == nil {
+			http.Error(w, noRules, http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, ruleList)
+	}
+}
+
+func (bh *BaseHandler) getRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ruleID, ok := parseRuleID(req)
+		if !ok {
+			http.Error(w, ruleNotFound, http.StatusNotFound)
+			return
+		}
+
+		rule, err := bh.repo.GetRule(ruleID)
+		if err != nil {
+			writeRuleError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, rule)
+	}
+}
+
+func (bh *BaseHandler) createRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		rule, ok := decodeRule(w, req)
+		if !ok {
+			return
+		}
+
+		rule, err := bh.repo.CreateRule(rule)
+		if err != nil {
+			writeRuleError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, rule)
+	}
+}
+
+func (bh *BaseHandler) updateRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ruleID, ok := parseRuleID(req)
+		if !ok {
+			http.Error(w, ruleNotFound, http.StatusNotFound)
+			return
+		}
+
+		rule, ok := decodeRule(w, req)
+		if !ok {
+			return
+		}
+		rule.ID = ruleID
+
+		err := bh.repo.UpdateRule(rule)
+		if err != nil {
+			writeRuleError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, rule)
+	}
+}
+
+func (bh *BaseHandler) deleteRule() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ruleID, ok := parseRuleID(req)
+		if !ok {
+			http.Error(w, ruleNotFound, http.StatusNotFound)
+			return
+		}
+
+		err := bh.repo.DeleteRule(ruleID)
+		if err != nil {
+			writeRuleError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (bh *BaseHandler) getRuleFirings() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		firings, err := bh.repo.GetRuleFirings(chi.URLParam(req, numberURLParam))
+		if err != nil {
+			writeRuleError(w, err)
+			return
+		}
+
+		if firings == nil {
+			http.Error(w, noRuleFirings, http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, firings)
+	}
+}
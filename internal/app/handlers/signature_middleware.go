@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+const (
+	signatureHeader        = "X-Accrual-Signature"
+	signatureTimestamp     = "X-Accrual-Timestamp"
+	signaturePrefix        = "sha256="
+	signatureMaxSkew       = 5 * time.Minute
+	webhookDisabled        = "Webhook disabled"
+	invalidSignature       = "Invalid signature"
+	errWebhookDisabled     = "accrual webhook is disabled: webhook secret is not configured"
+	errSignatureMismatched = "accrual webhook signature mismatch"
+)
+
+// signPayload подписывает тело callback-а вместе с меткой времени,
+// чтобы перехваченный запрос нельзя было переиграть позже signatureMaxSkew.
+func signPayload(secret string, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return signaturePrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+func signatureHandle(secret string) (sh func(http.Handler) http.Handler) {
+	sh = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if secret == "" {
+				http.Error(w, webhookDisabled, http.StatusForbidden)
+				logger.Logger.Err(errors.New(errWebhookDisabled)).Msg("")
+				return
+			}
+
+			timestamp := r.Header.Get(signatureTimestamp)
+			unix, err := strconv.ParseInt(timestamp, 10, 64)
+			if err != nil {
+				http.Error(w, invalidSignature, http.StatusUnauthorized)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
+			skew := time.Since(time.Unix(unix, 0))
+			if skew > signatureMaxSkew || skew < -signatureMaxSkew {
+				http.Error(w, invalidSignature, http.StatusUnauthorized)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
+
+			signature := r.Header.Get(signatureHeader)
+			expected := signPayload(secret, timestamp, body)
+			if !strings.HasPrefix(signature, signaturePrefix) || !hmac.Equal([]byte(signature), []byte(expected)) {
+				http.Error(w, invalidSignature, http.StatusUnauthorized)
+				logger.Logger.Err(errors.New(errSignatureMismatched)).Msg("")
+				return
+			}
+
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			h.ServeHTTP(w, r)
+		})
+	}
+	return
+}
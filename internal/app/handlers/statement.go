@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/xuri/excelize/v2"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	statementSheet    = "Statement"
+	statementFlushRow = 100
+	openingBalance    = "OPENING_BALANCE"
+	closingBalance    = "CLOSING_BALANCE"
+	xlsxContentType   = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+var statementHeader = []string{"date", "type", "order", "amount", "balance"}
+
+// statementWriter пишет выписку прямо в ответ. started сообщает, что заголовки уже отправлены
+// и ошибку больше нельзя вернуть клиенту статус-кодом.
+type statementWriter interface {
+	storage.StatementWriter
+	started() bool
+}
+
+// statementCloser - writer, которому после выписки нужно освободить ресурсы, даже если она прервалась ошибкой.
+type statementCloser interface {
+	close()
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+type csvStatement struct {
+	w      http.ResponseWriter
+	csv    *csv.Writer
+	name   string
+	rows   int
+	header bool
+}
+
+func newCSVStatement(w http.ResponseWriter, name string) *csvStatement {
+	return &csvStatement{w: w, csv: csv.NewWriter(w), name: name}
+}
+
+func (s *csvStatement) started() bool {
+	return s.header
+}
+
+func (s *csvStatement) Begin(opening float64) error {
+	s.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	s.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, s.name))
+	s.w.WriteHeader(http.StatusOK)
+	s.header = true
+
+	if err := s.csv.Write(statementHeader); err != nil {
+		return err
+	}
+	return s.csv.Write([]string{"", openingBalance, "", "", formatAmount(opening)})
+}
+
+func (s *csvStatement) Entry(entry entity.StatementEntry) error {
+	err := s.csv.Write([]string{entry.Date, entry.Type, entry.OrderID, formatAmount(entry.Amount), formatAmount(entry.Balance)})
+	if err != nil {
+		return err
+	}
+
+	s.rows++
+	if s.rows%statementFlushRow == 0 {
+		s.csv.Flush()
+		flush(s.w)
+		return s.csv.Error()
+	}
+	return nil
+}
+
+func (s *csvStatement) End(closing float64) error {
+	if err := s.csv.Write([]string{"", closingBalance, "", "", formatAmount(closing)}); err != nil {
+		return err
+	}
+	s.csv.Flush()
+	return s.csv.Error()
+}
+
+type jsonStatement struct {
+	w      http.ResponseWriter
+	rows   int
+	header bool
+}
+
+func newJSONStatement(w http.ResponseWriter) *jsonStatement {
+	return &jsonStatement{w: w}
+}
+
+func (s *jsonStatement) started() bool {
+	return s.header
+}
+
+func (s *jsonStatement) Begin(opening float64) error {
+	s.w.Header().Set("Content-Type", "application/json")
+	s.w.WriteHeader(http.StatusOK)
+	s.header = true
+
+	_, err := fmt.Fprintf(s.w, `{"opening_balance":%s,"entries":[`, formatAmount(opening))
+	return err
+}
+
+func (s *jsonStatement) Entry(entry entity.StatementEntry) error {
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if s.rows > 0 {
+		buf = append([]byte{','}, buf...)
+	}
+	if _, err := s.w.Write(buf); err != nil {
+		return err
+	}
+
+	s.rows++
+	if s.rows%statementFlushRow == 0 {
+		flush(s.w)
+	}
+	return nil
+}
+
+func (s *jsonStatement) End(closing float64) error {
+	_, err := fmt.Fprintf(s.w, `],"closing_balance":%s}`, formatAmount(closing))
+	return err
+}
+
+// xlsxStatement пишет строки через потоковый writer excelize, который сбрасывает их
+// во временный файл, а не держит весь лист в памяти. В отличие от CSV и JSON, клиент получает файл
+// только в End: xlsx - zip-архив, и excelize собирает его целиком; зато ошибку до End ещё можно вернуть кодом 500.
+// Временные файлы удаляет close, который обработчик вызывает и при ошибке выписки.
+type xlsxStatement struct {
+	w      http.ResponseWriter
+	file   *excelize.File
+	sheet  *excelize.StreamWriter
+	name   string
+	row    int
+	header bool
+}
+
+func newXLSXStatement(w http.ResponseWriter, name string) *xlsxStatement {
+	return &xlsxStatement{w: w, name: name}
+}
+
+func (s *xlsxStatement) started() bool {
+	return s.header
+}
+
+func (s *xlsxStatement) setRow(values ...interface{}) error {
+	s.row++
+	cell, err := excelize.CoordinatesToCellName(1, s.row)
+	if err != nil {
+		return err
+	}
+	return s.sheet.SetRow(cell, values)
+}
+
+func (s *xlsxStatement) Begin(opening float64) error {
+	s.file = excelize.NewFile()
+	if err := s.file.SetSheetName(s.file.GetSheetName(0), statementSheet); err != nil {
+		return err
+	}
+	sheet, err := s.file.NewStreamWriter(statementSheet)
+	if err != nil {
+		return err
+	}
+	s.sheet = sheet
+
+	header := make([]interface{}, len(statementHeader))
+	for i, h := range statementHeader {
+		header[i] = h
+	}
+	if err := s.setRow(header...); err != nil {
+		return err
+	}
+	return s.setRow("", openingBalance, "", "", opening)
+}
+
+func (s *xlsxStatement) Entry(entry entity.StatementEntry) error {
+	return s.setRow(entry.Date, entry.Type, entry.OrderID, entry.Amount, entry.Balance)
+}
+
+func (s *xlsxStatement) close() {
+	if s.file == nil {
+		return
+	}
+	if err := s.file.Close(); err != nil {
+		logger.Logger.Err(err).Msg("")
+	}
+}
+
+func (s *xlsxStatement) End(closing float64) error {
+	if err := s.setRow("", closingBalance, "", "", closing); err != nil {
+		return err
+	}
+	if err := s.sheet.Flush(); err != nil {
+		return err
+	}
+
+	s.w.Header().Set("Content-Type", xlsxContentType)
+	s.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, s.name))
+	s.w.WriteHeader(http.StatusOK)
+	s.header = true
+
+	return s.file.Write(s.w)
+}
+
+func (bh *BaseHandler) statement() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		values := req.URL.Query()
+		from, err := parseTime(values, "from")
+		if err != nil {
+			http.Error(w, invalidQuery, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+		to, err := parseTime(values, "to")
+		if err != nil {
+			http.Error(w, invalidQuery, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		name := "statement"
+		if !from.IsZero() {
+			name += "-" + from.Format(dateLayout)
+		}
+		if !to.IsZero() {
+			name += "-" + to.Format(dateLayout)
+		}
+
+		var sw statementWriter
+		switch values.Get("format") {
+		case "", "json":
+			sw = newJSONStatement(w)
+		case "csv":
+			sw = newCSVStatement(w, name)
+		case "xlsx":
+			sw = newXLSXStatement(w, name)
+		default:
+			http.Error(w, invalidQuery, http.StatusBadRequest)
+			return
+		}
+
+		if c, ok := sw.(statementCloser); ok {
+			defer c.close()
+		}
+
+		err = bh.repo.StreamStatement(userID, from, to, sw)
+		if err != nil {
+			if !sw.started() {
+				http.Error(w, internalServerError, http.StatusInternalServerError)
+			}
+			logger.Logger.Err(err).Msg("")
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+// Протокол терминала: клиент шлёт JSON-запросы TerminalRequest, сервер отвечает TerminalResponse с тем же id
+// и кодом, который вернул бы соответствующий HTTP-метод. Кроме ответов сервер сам присылает события
+// заказов и баланса пользователя (type "order" и "balance", без id и status).
+// Если соединение открыто по ключу API, ключ перепроверяется перед каждым запросом и раз в terminalKeyCheckPeriod:
+// после отзыва ключа сервер шлёт ответ 401 и закрывает соединение.
+
+const (
+	TerminalUploadOrder = "upload_order"
+	TerminalBalance     = "balance"
+	TerminalWithdraw    = "withdraw"
+)
+
+const (
+	unknownMessageType    = "Unknown message type"
+	terminalMaxMessage    = 4096
+	terminalPongWait      = 60 * time.Second
+	terminalPingPeriod    = terminalPongWait * 9 / 10
+	terminalWriteWait     = 10 * time.Second
+	terminalOutboxSize    = 16
+	terminalKeyCheck      = 30 * time.Second
+	terminalCloseNormally = "bye"
+)
+
+type TerminalRequest struct {
+	ID       string  `json:"id"`
+	Type     string  `json:"type"`
+	Order    string  `json:"order,omitempty"`
+	Sum      float64 `json:"sum,omitempty"`
+	Merchant string  `json:"merchant,omitempty"`
+}
+
+type TerminalResponse struct {
+	ID     string      `json:"id,omitempty"`
+	Type   string      `json:"type,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+func (bh *BaseHandler) terminal() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			// Upgrade уже ответил клиенту
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+		defer conn.Close()
+
+		apiKey := getAPIKey(req)
+
+		events, unsubscribe := bh.repo.Subscribe(userID)
+		defer unsubscribe()
+
+		outbox := make(chan TerminalResponse, terminalOutboxSize)
+		done := make(chan struct{})
+		go terminalWriter(conn, outbox, done)
+		defer func() {
+			close(outbox)
+			<-done
+		}()
+
+		conn.SetReadLimit(terminalMaxMessage)
+		_ = conn.SetReadDeadline(time.Now().Add(terminalPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(terminalPongWait))
+		})
+
+		messages := make(chan []byte)
+		go func() {
+			defer close(messages)
+			for {
+				_, data, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				select {
+				case messages <- data:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		keyCheck := time.NewTicker(terminalKeyCheck)
+		defer keyCheck.Stop()
+
+		for {
+			var resp TerminalResponse
+			revoked := false
+			select {
+			case <-done:
+				return
+			case <-keyCheck.C:
+				if resp, revoked = bh.terminalCheckKey(apiKey); !revoked {
+					continue
+				}
+			case data, ok := <-messages:
+				if !ok {
+					return
+				}
+				var msg TerminalRequest
+				err := json.Unmarshal(data, &msg)
+				if resp, revoked = bh.terminalCheckKey(apiKey); revoked {
+					resp.ID = msg.ID
+				} else if err != nil {
+					resp = TerminalResponse{Status: http.StatusBadRequest, Error: invalidJSON}
+				} else {
+					resp = bh.terminalHandle(userID, msg)
+				}
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				resp = TerminalResponse{Type: ev.Type, Data: ev.Data}
+			}
+
+			select {
+			case outbox <- resp:
+			case <-done:
+				return
+			}
+			if revoked {
+				return
+			}
+		}
+	}
+}
+
+// terminalCheckKey перепроверяет ключ API соединения. revoked означает, что ключ отозван
+// или проверить его не удалось, и соединение надо закрыть после ответа resp.
+func (bh *BaseHandler) terminalCheckKey(apiKey string) (resp TerminalResponse, revoked bool) {
+	if apiKey == "" {
+		return resp, false
+	}
+	if _, err := bh.repo.AuthenticateAPIKey(apiKey); err != nil {
+		if errors.Is(err, storage.ErrInvalidAPIKey) {
+			return TerminalResponse{Status: http.StatusUnauthorized, Error: invalidAPIKey}, true
+		}
+		logger.Logger.Err(err).Msg("")
+		return TerminalResponse{Status: http.StatusInternalServerError, Error: internalServerError}, true
+	}
+	return resp, false
+}
+
+// terminalWriter - единственный писатель в соединение: ответы, события и ping.
+func terminalWriter(conn *websocket.Conn, outbox <-chan TerminalResponse, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(terminalPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-outbox:
+			_ = conn.SetWriteDeadline(time.Now().Add(terminalWriteWait))
+			if !ok {
+				_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, terminalCloseNormally))
+				return
+			}
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(terminalWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// terminalHandle выполняет запрос терминала с теми же проверками и кодами ответа, что и HTTP API.
+func (bh *BaseHandler) terminalHandle(userID string, msg TerminalRequest) TerminalResponse {
+	resp := TerminalResponse{ID: msg.ID, Type: msg.Type}
+
+	switch msg.Type {
+	case TerminalUploadOrder:
+		if code, errMsg := validateOrderNumber(msg.Order); code != 0 {
+			resp.Status, resp.Error = code, errMsg
+			return resp
+		}
+		resp.Status, resp.Error = loadOrderResult(bh.repo.LoadOrder(msg.Order, userID, msg.Merchant))
+	case TerminalBalance:
+		balance, err := bh.repo.GetBalance(userID)
+		if err != nil {
+			logger.Logger.Err(err).Msg("")
+			resp.Status, resp.Error = http.StatusInternalServerError, internalServerError
+			return resp
+		}
+		resp.Status, resp.Data = http.StatusOK, balance
+	case TerminalWithdraw:
+		if code, errMsg := validateWithdrawal(Withdrawal{Order: msg.Order, Sum: msg.Sum}); code != 0 {
+			resp.Status, resp.Error = code, errMsg
+			return resp
+		}
+		status, err := bh.repo.Withdraw(msg.Order, userID, msg.Sum)
+		if err != nil {
+			resp.Status, resp.Error = withdrawResult(err)
+			return resp
+		}
+		resp.Status = http.StatusOK
+		if status == storage.WithdrawalReview {
+			resp.Status = http.StatusAccepted
+		}
+		resp.Data = WithdrawalStatus{Order: msg.Order, Status: status}
+	default:
+		resp.Status, resp.Error = http.StatusBadRequest, unknownMessageType
+	}
+	return resp
+}
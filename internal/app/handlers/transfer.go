@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	noTransfers           = "No transfers"
+	recipientNotFound     = "Recipient not found"
+	selfTransfer          = "Cannot transfer to yourself"
+	transferLimitExceeded = "Daily transfer limit exceeded"
+	transferNotFound      = "Transfer not found"
+	transferNotPending    = "Transfer is not pending"
+	transferIDURLParam    = "id"
+)
+
+type TransferRequest struct {
+	To      string  `json:"to"`
+	Sum     float64 `json:"sum"`
+	Comment string  `json:"comment"`
+}
+
+type TransferSettings struct {
+	RequireConfirmation bool `json:"require_confirmation"`
+}
+
+func (bh *BaseHandler) transfer() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		var transferReq TransferRequest
+		if err := json.NewDecoder(req.Body).Decode(&transferReq); err != nil {
+			http.Error(w, invalidJSON, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+		if transferReq.To == "" || transferReq.Sum <= 0 {
+			http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+			return
+		}
+
+		transfer, err := bh.repo.Transfer(userID, transferReq.To, transferReq.Sum, transferReq.Comment)
+		if err != nil {
+			if errors.Is(err, storage.ErrRecipientNotFound) {
+				http.Error(w, recipientNotFound, http.StatusNotFound)
+			} else if errors.Is(err, storage.ErrSelfTransfer) {
+				http.Error(w, selfTransfer, http.StatusBadRequest)
+			} else if errors.Is(err, storage.ErrTransferLimitExceeded) {
+				http.Error(w, transferLimitExceeded, http.StatusForbidden)
+			} else if errors.Is(err, storage.ErrInsufficientFunds) {
+				http.Error(w, insufficientFunds, http.StatusPaymentRequired)
+			} else {
+				http.Error(w, internalServerError, http.StatusInternalServerError)
+				logger.Logger.Err(err).Msg("")
+			}
+			return
+		}
+
+		status := http.StatusOK
+		if transfer.Status == storage.TransferPending {
+			status = http.StatusAccepted
+		}
+		writeJSON(w, status, transfer)
+	}
+}
+
+func (bh *BaseHandler) getTransfers() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		transfers, err := bh.repo.GetTransfers(userID)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		if transfers == nil {
+			http.Error(w, noTransfers, http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, transfers)
+	}
+}
+
+func (bh *BaseHandler) resolveTransfer(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		transferID, err := strconv.ParseInt(chi.URLParam(req, transferIDURLParam), 10, 64)
+		if err != nil {
+			http.Error(w, transferNotFound, http.StatusNotFound)
+			return
+		}
+
+		err = bh.repo.ResolveTransfer(userID, transferID, action)
+		if err != nil {
+			if errors.Is(err, storage.ErrTransferNotFound) {
+				http.Error(w, transferNotFound, http.StatusNotFound)
+			} else if errors.Is(err, storage.ErrTransferNotPending) {
+				http.Error(w, transferNotPending, http.StatusConflict)
+			} else {
+				http.Error(w, internalServerError, http.StatusInternalServerError)
+				logger.Logger.Err(err).Msg("")
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (bh *BaseHandler) setTransferSettings() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		var settings TransferSettings
+		if err := json.NewDecoder(req.Body).Decode(&settings); err != nil {
+			http.Error(w, invalidJSON, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		err = bh.repo.SetTransferConfirmation(userID, settings.RequireConfirmation)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, settings)
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	noVoucherBatches         = "No voucher batches"
+	voucherNotFound          = "Voucher not found"
+	voucherExpired           = "Voucher expired"
+	voucherExhausted         = "Voucher usage limit reached"
+	voucherAlreadyRedeemed   = "Voucher already redeemed"
+	maxVouchersPerBatch      = 10000
+	defaultVoucherUsageLimit = 1
+)
+
+type MintRequest struct {
+	Count      int        `json:"count"`
+	Value      float64    `json:"value"`
+	UsageLimit int        `json:"usage_limit"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+type RedeemRequest struct {
+	Code string `json:"code"`
+}
+
+func (bh *BaseHandler) mintVouchers() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var mintReq MintRequest
+		if err := json.NewDecoder(req.Body).Decode(&mintReq); err != nil {
+			http.Error(w, invalidJSON, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+		if mintReq.UsageLimit == 0 {
+			mintReq.UsageLimit = defaultVoucherUsageLimit
+		}
+		if mintReq.Count <= 0 || mintReq.Count > maxVouchersPerBatch || mintReq.Value <= 0 || mintReq.UsageLimit < 0 ||
+			(mintReq.ExpiresAt != nil && !mintReq.ExpiresAt.After(time.Now())) {
+			http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+			return
+		}
+
+		batch, err := bh.repo.MintVouchers(mintReq.Count, mintReq.Value, mintReq.UsageLimit, mintReq.ExpiresAt)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, batch)
+	}
+}
+
+func (bh *BaseHandler) getVoucherBatches() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		batches, err := bh.repo.GetVoucherBatches()
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		if batches == nil {
+			http.Error(w, noVoucherBatches, http.StatusNoContent)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, batches)
+	}
+}
+
+func (bh *BaseHandler) redeemVoucher() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		userID, err := getUserID(req)
+		if err != nil {
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		var redeemReq RedeemRequest
+		if err := json.NewDecoder(req.Body).Decode(&redeemReq); err != nil {
+			http.Error(w, invalidJSON, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+		if redeemReq.Code == "" {
+			http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+			return
+		}
+
+		redemption, err := bh.repo.RedeemVoucher(userID, redeemReq.Code)
+		if err != nil {
+			if errors.Is(err, storage.ErrVoucherNotFound) {
+				http.Error(w, voucherNotFound, http.StatusNotFound)
+			} else if errors.Is(err, storage.ErrVoucherExpired) {
+				http.Error(w, voucherExpired, http.StatusGone)
+			} else if errors.Is(err, storage.ErrVoucherExhausted) {
+				http.Error(w, voucherExhausted, http.StatusConflict)
+			} else if errors.Is(err, storage.ErrVoucherAlreadyRedeemed) {
+				http.Error(w, voucherAlreadyRedeemed, http.StatusConflict)
+			} else {
+				http.Error(w, internalServerError, http.StatusInternalServerError)
+				logger.Logger.Err(err).Msg("")
+			}
+			return
+		}
+
+		writeJSON(w, http.StatusOK, redemption)
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+const (
+	orderNotFound = "Order not found"
+)
+
+type AccrualCallback struct {
+	Order   string  `json:"order"`
+	Status  string  `json:"status"`
+	Accrual float64 `json:"accrual"`
+}
+
+func (bh *BaseHandler) accrualCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var callback AccrualCallback
+		if err := json.NewDecoder(req.Body).Decode(&callback); err != nil {
+			http.Error(w, invalidJSON, http.StatusBadRequest)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		if !(callback.Accrual >= 0) || math.IsInf(callback.Accrual, 0) {
+			http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+			return
+		}
+
+		err := bh.repo.ApplyAccrual(callback.Order, callback.Status, callback.Accrual)
+		if err != nil {
+			if errors.Is(err, storage.ErrOrderNotFound) {
+				http.Error(w, orderNotFound, http.StatusNotFound)
+				return
+			} else if errors.Is(err, storage.ErrUnknownAccrualStatus) || errors.Is(err, storage.ErrInvalidAccrual) {
+				http.Error(w, invalidRequestFormat, http.StatusBadRequest)
+				logger.Logger.Err(err).Msg("")
+				return
+			}
+			http.Error(w, internalServerError, http.StatusInternalServerError)
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Некорректное начисление отклоняется до обращения к хранилищу, поэтому repo не нужен.
+func TestAccrualCallbackRejectsInvalidAccrual(t *testing.T) {
+	handler := (&BaseHandler{}).accrualCallback()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"negative", `{"order":"79927398713","status":"PROCESSED","accrual":-10}`},
+		{"out of range", `{"order":"79927398713","status":"PROCESSED","accrual":1e400}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodPost, "/internal/accrual/callback", strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
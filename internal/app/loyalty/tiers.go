@@ -0,0 +1,126 @@
+package loyalty
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+const defaultWindowMonths = 12
+
+// Tier - уровень программы лояльности. Пользователь получает уровень с наибольшим Threshold,
+// не превышающим сумму его начислений за последние WindowMonths месяцев; Multiplier умножает начисления.
+type Tier struct {
+	Name       string  `json:"name"`
+	Threshold  float64 `json:"threshold"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+type Config struct {
+	WindowMonths int    `json:"window_months"`
+	Tiers        []Tier `json:"tiers"`
+}
+
+// DefaultConfig - один уровень без повышающего коэффициента, начисления не меняются.
+func DefaultConfig() Config {
+	return Config{
+		WindowMonths: defaultWindowMonths,
+		Tiers:        []Tier{{Name: "Bronze", Threshold: 0, Multiplier: 1}},
+	}
+}
+
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	f, err := os.Open(path)
+	if err != nil {
+		return cfg, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+type Tiers struct {
+	windowMonths int
+	tiers        []Tier
+}
+
+func NewTiers(cfg Config) (*Tiers, error) {
+	if len(cfg.Tiers) == 0 {
+		return nil, fmt.Errorf("no loyalty tiers")
+	}
+
+	t := &Tiers{
+		windowMonths: cfg.WindowMonths,
+		tiers:        append([]Tier(nil), cfg.Tiers...),
+	}
+	if t.windowMonths <= 0 {
+		t.windowMonths = defaultWindowMonths
+	}
+
+	names := make(map[string]bool, len(t.tiers))
+	for _, tier := range t.tiers {
+		if tier.Name == "" {
+			return nil, fmt.Errorf("loyalty tier without name")
+		}
+		if names[tier.Name] {
+			return nil, fmt.Errorf("duplicate loyalty tier %q", tier.Name)
+		}
+		names[tier.Name] = true
+		if tier.Multiplier <= 0 {
+			return nil, fmt.Errorf("loyalty tier %q: multiplier must be positive", tier.Name)
+		}
+		if tier.Threshold < 0 {
+			return nil, fmt.Errorf("loyalty tier %q: negative threshold", tier.Name)
+		}
+	}
+
+	sort.SliceStable(t.tiers, func(i, j int) bool {
+		return t.tiers[i].Threshold < t.tiers[j].Threshold
+	})
+
+	return t, nil
+}
+
+// Tier возвращает уровень для суммы начислений за окно. Самый низкий уровень достаётся всем.
+func (t *Tiers) Tier(accrued float64) Tier {
+	tier := t.tiers[0]
+	for _, candidate := range t.tiers[1:] {
+		if accrued < candidate.Threshold {
+			break
+		}
+		tier = candidate
+	}
+	return tier
+}
+
+// Next возвращает уровень, следующий за current, или false, если current наивысший.
+func (t *Tiers) Next(current Tier) (Tier, bool) {
+	for _, tier := range t.tiers {
+		if tier.Threshold > current.Threshold {
+			return tier, true
+		}
+	}
+	return Tier{}, false
+}
+
+// ByName возвращает уровень по имени. Неизвестные (например, удалённые из конфигурации)
+// и пустые имена считаются самым низким уровнем.
+func (t *Tiers) ByName(name string) Tier {
+	for _, tier := range t.tiers {
+		if tier.Name == name {
+			return tier
+		}
+	}
+	return t.tiers[0]
+}
+
+// WindowStart - начало скользящего окна, за которое считаются начисления.
+func (t *Tiers) WindowStart(now time.Time) time.Time {
+	return now.AddDate(0, -t.windowMonths, 0)
+}
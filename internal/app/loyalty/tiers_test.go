@@ -0,0 +1,90 @@
+package loyalty
+
+import (
+	"testing"
+)
+
+func testTiers(t *testing.T) *Tiers {
+	t.Helper()
+	// уровни намеренно не по порядку: NewTiers сортирует их по порогу
+	tiers, err := NewTiers(Config{Tiers: []Tier{
+		{Name: "Gold", Threshold: 5000, Multiplier: 1.25},
+		{Name: "Bronze", Threshold: 0, Multiplier: 1},
+		{Name: "Silver", Threshold: 1000, Multiplier: 1.1},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tiers
+}
+
+func TestTiersTier(t *testing.T) {
+	tiers := testTiers(t)
+
+	tests := []struct {
+		name    string
+		accrued float64
+		want    string
+	}{
+		{"nothing accrued", 0, "Bronze"},
+		{"below silver", 999.99, "Bronze"},
+		{"silver threshold", 1000, "Silver"},
+		{"between", 4999, "Silver"},
+		{"gold threshold", 5000, "Gold"},
+		{"above top", 1e9, "Gold"},
+		{"negative after reversals", -10, "Bronze"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tiers.Tier(tt.accrued).Name; got != tt.want {
+				t.Errorf("Tier(%v) = %s, want %s", tt.accrued, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTiersNext(t *testing.T) {
+	tiers := testTiers(t)
+
+	tests := []struct {
+		current string
+		want    string
+		wantOK  bool
+	}{
+		{"Bronze", "Silver", true},
+		{"Silver", "Gold", true},
+		{"Gold", "", false},
+		{"Removed", "Silver", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.current, func(t *testing.T) {
+			next, ok := tiers.Next(tiers.ByName(tt.current))
+			if ok != tt.wantOK || next.Name != tt.want {
+				t.Errorf("Next(%s) = %s, %v, want %s, %v", tt.current, next.Name, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewTiersInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"empty", Config{}},
+		{"no name", Config{Tiers: []Tier{{Multiplier: 1}}}},
+		{"duplicate", Config{Tiers: []Tier{{Name: "A", Multiplier: 1}, {Name: "A", Threshold: 10, Multiplier: 2}}}},
+		{"zero multiplier", Config{Tiers: []Tier{{Name: "A"}}}},
+		{"negative threshold", Config{Tiers: []Tier{{Name: "A", Threshold: -1, Multiplier: 1}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTiers(tt.cfg); err == nil {
+				t.Error("NewTiers() succeeded, want error")
+			}
+		})
+	}
+}
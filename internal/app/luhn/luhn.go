@@ -0,0 +1,28 @@
+package luhn
+
+import "strconv"
+
+// Check проверяет контрольную цифру номера по алгоритму Луна; ошибка - номер не является числом.
+func Check(orderID string) (bool, error) {
+	number, err := strconv.Atoi(orderID)
+	if err != nil {
+		return false, err
+	}
+	payload := number / 10
+
+	var luhn int
+	for i := 0; payload > 0; i++ {
+		cur := payload % 10
+		if i%2 == 0 { // even
+			cur = cur * 2
+			if cur > 9 {
+				cur = cur%10 + cur/10
+			}
+		}
+		luhn += cur
+		payload = payload / 10
+	}
+	checksum := luhn % 10
+
+	return (number%10+checksum)%10 == 0, nil
+}
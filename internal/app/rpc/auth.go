@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/devkekops/gophermart/internal/app/auth"
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/rpc/pb"
+)
+
+type key string
+
+const (
+	sessionKey     = "session"
+	userIDKey  key = "userID"
+)
+
+// публичные методы, для остальных нужна сессия
+var public = map[string]bool{
+	pb.Gophermart_Register_FullMethodName: true,
+	pb.Gophermart_Login_FullMethodName:    true,
+}
+
+// authenticate - аналог authHandle: проверяет сессию из метаданных и кладёт userID в контекст.
+func authenticate(ctx context.Context, secretKey string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	sessions := md.Get(sessionKey)
+	if len(sessions) == 0 {
+		return nil, status.Error(codes.Unauthenticated, invalidCredentials)
+	}
+
+	userID, err := auth.CheckSession(sessions[0], secretKey)
+	if err != nil {
+		logger.Logger.Err(err).Msg("")
+		return nil, status.Error(codes.Unauthenticated, invalidSession)
+	}
+
+	return context.WithValue(ctx, userIDKey, userID), nil
+}
+
+func unaryAuth(secretKey string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if public[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		ctx, err := authenticate(ctx, secretKey)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authStream подменяет контекст потока на контекст с userID.
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+func streamAuth(secretKey string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if public[info.FullMethod] {
+			return handler(srv, ss)
+		}
+
+		ctx, err := authenticate(ss.Context(), secretKey)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func getUserID(ctx context.Context) (string, error) {
+	userID, ok := ctx.Value(userIDKey).(string)
+	if !ok {
+		return "", status.Error(codes.Internal, internalServerError)
+	}
+	return userID, nil
+}
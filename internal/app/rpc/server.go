@@ -0,0 +1,310 @@
+package rpc
+
+//go:generate protoc -I ../../../proto --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative gophermart.proto
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/devkekops/gophermart/internal/app/auth"
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/events"
+	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/luhn"
+	"github.com/devkekops/gophermart/internal/app/rpc/pb"
+	"github.com/devkekops/gophermart/internal/app/storage"
+)
+
+// Коды ответов соответствуют HTTP API: 401 - Unauthenticated, 409 - AlreadyExists, 402 - FailedPrecondition,
+// 403 - PermissionDenied, 400 и 422 - InvalidArgument.
+
+const (
+	maxPageLimit         = 1000
+	internalServerError  = "Internal Server Error"
+	invalidCredentials   = "Invalid credentials"
+	invalidSession       = "Invalid session"
+	invalidRequestFormat = "Invalid request format"
+	invalidOrderNumber   = "Invalid order number"
+	invalidSum           = "Sum must be positive"
+	invalidQuery         = "Invalid query parameters"
+	loginAlreadyInUse    = "Login already in use"
+	unknownReferralCode  = "Unknown referral code"
+	orderUploadedByOther = "Order already uploaded by another user"
+	insufficientFunds    = "Insuficient funds"
+	withdrawalExists     = "Order already paid with points"
+	withdrawalLimit      = "Withdrawal limit exceeded"
+	accountTooNew        = "Account is too new to withdraw"
+	eventStreamOverflow  = "Event stream overflow"
+)
+
+type Server struct {
+	pb.UnimplementedGophermartServer
+	repo      storage.Repository
+	secretKey string
+}
+
+// NewServer создаёт gRPC-сервер Gophermart поверх того же хранилища, что и HTTP API.
+func NewServer(repo storage.Repository, secretKey string) *grpc.Server {
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(unaryAuth(secretKey)),
+		grpc.StreamInterceptor(streamAuth(secretKey)),
+	)
+	pb.RegisterGophermartServer(srv, &Server{repo: repo, secretKey: secretKey})
+	return srv
+}
+
+func internalError(err error) error {
+	logger.Logger.Err(err).Msg("")
+	return status.Error(codes.Internal, internalServerError)
+}
+
+func validateOrderNumber(orderID string) error {
+	check, err := luhn.Check(orderID)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, invalidRequestFormat)
+	}
+	if !check {
+		return status.Error(codes.InvalidArgument, invalidOrderNumber)
+	}
+	return nil
+}
+
+func pageLimit(limit int32) (int, error) {
+	if limit < 0 {
+		return 0, status.Error(codes.InvalidArgument, invalidQuery)
+	}
+	if limit > maxPageLimit {
+		return maxPageLimit, nil
+	}
+	return int(limit), nil
+}
+
+func (s *Server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.SessionResponse, error) {
+	userID, err := s.repo.CreateUser(req.Login, auth.PasswordHash(req.Password), req.ReferralCode)
+	if err != nil {
+		if errors.Is(err, storage.ErrUnknownReferralCode) {
+			return nil, status.Error(codes.InvalidArgument, unknownReferralCode)
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
+			return nil, status.Error(codes.AlreadyExists, loginAlreadyInUse)
+		}
+		return nil, internalError(err)
+	}
+
+	return &pb.SessionResponse{Session: auth.CreateSession(userID, s.secretKey)}, nil
+}
+
+func (s *Server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.SessionResponse, error) {
+	userID, err := s.repo.AuthUser(req.Login, auth.PasswordHash(req.Password))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Error(codes.Unauthenticated, invalidCredentials)
+		}
+		return nil, internalError(err)
+	}
+
+	return &pb.SessionResponse{Session: auth.CreateSession(userID, s.secretKey)}, nil
+}
+
+func (s *Server) UploadOrder(ctx context.Context, req *pb.UploadOrderRequest) (*pb.UploadOrderResponse, error) {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateOrderNumber(req.Number); err != nil {
+		return nil, err
+	}
+
+	err = s.repo.LoadOrder(req.Number, userID, req.Merchant)
+	if err != nil {
+		if errors.Is(err, storage.ErrOrderExistsForCurrentUser) {
+			return &pb.UploadOrderResponse{AlreadyUploaded: true}, nil
+		} else if errors.Is(err, storage.ErrOrderExistsForOtherUser) {
+			return nil, status.Error(codes.AlreadyExists, orderUploadedByOther)
+		}
+		return nil, internalError(err)
+	}
+
+	return &pb.UploadOrderResponse{}, nil
+}
+
+func (s *Server) ListOrders(ctx context.Context, req *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, st := range req.Statuses {
+		switch st {
+		case storage.NEW, storage.REGISTERED, storage.PROCESSING, storage.PROCESSED, storage.INVALID:
+		default:
+			return nil, status.Error(codes.InvalidArgument, invalidQuery)
+		}
+	}
+	limit, err := pageLimit(req.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	q := storage.OrdersQuery{Statuses: req.Statuses, Desc: req.Desc, Limit: limit, Cursor: req.Cursor}
+	orders, next, err := s.repo.GetOrders(userID, q)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			return nil, status.Error(codes.InvalidArgument, invalidQuery)
+		}
+		return nil, internalError(err)
+	}
+
+	resp := &pb.ListOrdersResponse{NextCursor: next}
+	for _, o := range orders {
+		resp.Orders = append(resp.Orders, &pb.Order{
+			Number:     o.OrderID,
+			Status:     o.Status,
+			Accrual:    o.Accrual,
+			UploadedAt: o.UploadedAt,
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) GetBalance(ctx context.Context, req *pb.GetBalanceRequest) (*pb.Balance, error) {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := s.repo.GetBalance(userID)
+	if err != nil {
+		return nil, internalError(err)
+	}
+
+	return &pb.Balance{
+		Current:   balance.Current,
+		Available: balance.Available,
+		Held:      balance.Held,
+		Withdrawn: balance.Withdrawn,
+		Debt:      balance.Debt,
+	}, nil
+}
+
+func (s *Server) Withdraw(ctx context.Context, req *pb.WithdrawRequest) (*pb.WithdrawResponse, error) {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateOrderNumber(req.Order); err != nil {
+		return nil, err
+	}
+	if !(req.Sum > 0) || math.IsInf(req.Sum, 0) {
+		return nil, status.Error(codes.InvalidArgument, invalidSum)
+	}
+
+	st, err := s.repo.Withdraw(req.Order, userID, req.Sum)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidSum) {
+			return nil, status.Error(codes.InvalidArgument, invalidSum)
+		} else if errors.Is(err, storage.ErrInsufficientFunds) {
+			return nil, status.Error(codes.FailedPrecondition, insufficientFunds)
+		} else if errors.Is(err, storage.ErrWithdrawalExists) {
+			return nil, status.Error(codes.AlreadyExists, withdrawalExists)
+		} else if errors.Is(err, storage.ErrWithdrawalLimitExceeded) {
+			return nil, status.Error(codes.PermissionDenied, withdrawalLimit)
+		} else if errors.Is(err, storage.ErrAccountTooNew) {
+			return nil, status.Error(codes.PermissionDenied, accountTooNew)
+		}
+		return nil, internalError(err)
+	}
+
+	return &pb.WithdrawResponse{Order: req.Order, Status: st}, nil
+}
+
+func (s *Server) ListWithdrawals(ctx context.Context, req *pb.ListWithdrawalsRequest) (*pb.ListWithdrawalsResponse, error) {
+	userID, err := getUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := pageLimit(req.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	q := storage.WithdrawalsQuery{Desc: req.Desc, Limit: limit, Cursor: req.Cursor}
+	page, err := s.repo.GetWithdrawals(userID, q)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			return nil, status.Error(codes.InvalidArgument, invalidQuery)
+		}
+		return nil, internalError(err)
+	}
+
+	resp := &pb.ListWithdrawalsResponse{
+		NextCursor: page.Next,
+		TotalCount: int32(page.Totals.Count),
+		TotalSum:   page.Totals.Sum,
+	}
+	for _, w := range page.Withdrawals {
+		withdrawal := &pb.Withdrawal{
+			Order:        w.OrderID,
+			Sum:          w.Sum,
+			Status:       w.Status,
+			ProcessedAt:  w.ProcessedAt,
+			RefundReason: w.RefundReason,
+		}
+		if w.RefundedAt != nil {
+			withdrawal.RefundedAt = *w.RefundedAt
+		}
+		resp.Withdrawals = append(resp.Withdrawals, withdrawal)
+	}
+	return resp, nil
+}
+
+func (s *Server) WatchOrders(req *pb.WatchOrdersRequest, stream pb.Gophermart_WatchOrdersServer) error {
+	userID, err := getUserID(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	ch, unsubscribe := s.repo.Subscribe(userID)
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case ev, ok := <-ch:
+			if !ok {
+				// подписчик не успевал читать события, клиент переподключится
+				return status.Error(codes.Unavailable, eventStreamOverflow)
+			}
+			if ev.Type != events.OrderEvent {
+				continue
+			}
+
+			var update entity.OrderUpdate
+			if err := json.Unmarshal(ev.Data, &update); err != nil {
+				return internalError(err)
+			}
+			err := stream.Send(&pb.OrderUpdate{
+				Number:  update.OrderID,
+				Status:  update.Status,
+				Accrual: update.Accrual,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
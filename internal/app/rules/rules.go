@@ -0,0 +1,204 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"MON": time.Monday,
+	"TUE": time.Tuesday,
+	"WED": time.Wednesday,
+	"THU": time.Thursday,
+	"FRI": time.Friday,
+	"SAT": time.Saturday,
+	"SUN": time.Sunday,
+}
+
+// Condition - условия срабатывания правила. Заданные условия должны выполниться все, пустые не проверяются.
+type Condition struct {
+	// Weekdays - дни недели расчёта заказа (MON..SUN) в часовом поясе правила.
+	Weekdays []string `json:"weekdays,omitempty"`
+	// FirstOrder - первый рассчитанный заказ пользователя.
+	FirstOrder bool `json:"first_order,omitempty"`
+	// OrdersInMonth - N-й рассчитанный заказ пользователя за календарный месяц (UTC), срабатывает один раз в месяц.
+	OrdersInMonth int     `json:"orders_in_month,omitempty"`
+	MinAccrual    float64 `json:"min_accrual,omitempty"`
+	Merchant      string  `json:"merchant,omitempty"`
+}
+
+// Action - бонус сработавшего правила: Multiplier добавляет accrual*(Multiplier-1), Bonus - фиксированную сумму.
+type Action struct {
+	Multiplier float64 `json:"multiplier,omitempty"`
+	Bonus      float64 `json:"bonus,omitempty"`
+}
+
+// Rule - правило акции. Правила проверяются по возрастанию Priority, при равенстве - по ID;
+// сработавшее правило со Stop прекращает проверку остальных.
+type Rule struct {
+	ID       int64      `json:"id"`
+	Name     string     `json:"name"`
+	Priority int        `json:"priority"`
+	Enabled  bool       `json:"enabled"`
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+	Timezone string     `json:"timezone,omitempty"`
+	When     Condition  `json:"when"`
+	Then     Action     `json:"then"`
+	Stop     bool       `json:"stop,omitempty"`
+}
+
+// LoadFile читает из JSON-файла массив правил и проверяет каждое.
+func LoadFile(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []Rule
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("rule %d in %s: %w", i, path, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("rule name is required")
+	}
+	if r.StartsAt != nil && r.EndsAt != nil && !r.EndsAt.After(*r.StartsAt) {
+		return errors.New("rule ends_at must be after starts_at")
+	}
+	if _, err := r.location(); err != nil {
+		return fmt.Errorf("rule timezone: %w", err)
+	}
+	for _, day := range r.When.Weekdays {
+		if _, ok := weekdays[strings.ToUpper(day)]; !ok {
+			return fmt.Errorf("unknown weekday %q", day)
+		}
+	}
+	if r.When.OrdersInMonth < 0 || r.When.MinAccrual < 0 {
+		return errors.New("rule conditions must not be negative")
+	}
+	if r.Then.Bonus < 0 {
+		return errors.New("rule bonus must not be negative")
+	}
+	if r.Then.Multiplier != 0 && r.Then.Multiplier < 1 {
+		return errors.New("rule multiplier must be at least 1")
+	}
+	if r.Then.Multiplier == 0 && r.Then.Bonus == 0 {
+		return errors.New("rule action is empty")
+	}
+	return nil
+}
+
+func (r Rule) location() (*time.Location, error) {
+	if r.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(r.Timezone)
+}
+
+// Facts - данные о рассчитанном заказе, по которым проверяются правила.
+type Facts struct {
+	ProcessedAt time.Time
+	// Accrual - начисление системы расчёта без коэффициента уровня, иначе множитель акции
+	// умножался бы на множитель уровня.
+	Accrual  float64
+	Merchant string
+	// PreviousOrders - рассчитанные заказы пользователя до этого.
+	PreviousOrders int
+	// OrdersInMonth - рассчитанные заказы пользователя за месяц ProcessedAt, включая этот.
+	OrdersInMonth int
+}
+
+type Result struct {
+	RuleID   int64
+	RuleName string
+	Bonus    float64
+}
+
+func (r Rule) matches(f Facts) bool {
+	if !r.Enabled {
+		return false
+	}
+	if r.StartsAt != nil && f.ProcessedAt.Before(*r.StartsAt) {
+		return false
+	}
+	if r.EndsAt != nil && !f.ProcessedAt.Before(*r.EndsAt) {
+		return false
+	}
+	if len(r.When.Weekdays) > 0 {
+		loc, err := r.location()
+		if err != nil {
+			return false
+		}
+		day := f.ProcessedAt.In(loc).Weekday()
+		found := false
+		for _, name := range r.When.Weekdays {
+			if weekdays[strings.ToUpper(name)] == day {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.When.FirstOrder && f.PreviousOrders != 0 {
+		return false
+	}
+	if r.When.OrdersInMonth > 0 && f.OrdersInMonth != r.When.OrdersInMonth {
+		return false
+	}
+	if f.Accrual < r.When.MinAccrual {
+		return false
+	}
+	if r.When.Merchant != "" && r.When.Merchant != f.Merchant {
+		return false
+	}
+	return true
+}
+
+func (a Action) bonus(accrual float64) float64 {
+	bonus := a.Bonus
+	if a.Multiplier > 0 {
+		bonus += accrual * (a.Multiplier - 1)
+	}
+	return math.Round(bonus*100) / 100
+}
+
+// Evaluate возвращает сработавшие правила в порядке проверки.
+func Evaluate(rules []Rule, f Facts) []Result {
+	ordered := append([]Rule(nil), rules...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Priority != ordered[j].Priority {
+			return ordered[i].Priority < ordered[j].Priority
+		}
+		return ordered[i].ID < ordered[j].ID
+	})
+
+	var results []Result
+	for _, rule := range ordered {
+		if !rule.matches(f) {
+			continue
+		}
+		results = append(results, Result{RuleID: rule.ID, RuleName: rule.Name, Bonus: rule.Then.bonus(f.Accrual)})
+		if rule.Stop {
+			break
+		}
+	}
+	return results
+}
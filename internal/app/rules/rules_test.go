@@ -0,0 +1,87 @@
+package rules
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRuleValidate(t *testing.T) {
+	starts := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ends := starts.AddDate(0, 1, 0)
+
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{"bonus", Rule{Name: "welcome", When: Condition{FirstOrder: true}, Then: Action{Bonus: 100}}, false},
+		{"multiplier with timezone", Rule{Name: "weekend", Timezone: "Europe/Moscow", When: Condition{Weekdays: []string{"sat", "SUN"}}, Then: Action{Multiplier: 2}}, false},
+		{"period", Rule{Name: "january", StartsAt: &starts, EndsAt: &ends, Then: Action{Bonus: 1}}, false},
+		{"no name", Rule{Then: Action{Bonus: 100}}, true},
+		{"ends before starts", Rule{Name: "x", StartsAt: &ends, EndsAt: &starts, Then: Action{Bonus: 1}}, true},
+		{"unknown timezone", Rule{Name: "x", Timezone: "Mars/Olympus", Then: Action{Bonus: 1}}, true},
+		{"unknown weekday", Rule{Name: "x", When: Condition{Weekdays: []string{"FUN"}}, Then: Action{Bonus: 1}}, true},
+		{"negative condition", Rule{Name: "x", When: Condition{MinAccrual: -1}, Then: Action{Bonus: 1}}, true},
+		{"negative bonus", Rule{Name: "x", Then: Action{Bonus: -1}}, true},
+		{"multiplier below one", Rule{Name: "x", Then: Action{Multiplier: 0.5}}, true},
+		{"empty action", Rule{Name: "x"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	// 2026-03-07 - суббота
+	saturday := time.Date(2026, 3, 7, 12, 0, 0, 0, time.UTC)
+	ends := saturday
+
+	weekend := Rule{ID: 1, Name: "weekend", Priority: 10, Enabled: true, When: Condition{Weekdays: []string{"SAT", "SUN"}}, Then: Action{Multiplier: 2}}
+	welcome := Rule{ID: 2, Name: "welcome", Priority: 20, Enabled: true, When: Condition{FirstOrder: true}, Then: Action{Bonus: 100}}
+	fifth := Rule{ID: 3, Name: "fifth", Priority: 20, Enabled: true, When: Condition{OrdersInMonth: 5}, Then: Action{Bonus: 250}}
+	merchant := Rule{ID: 4, Name: "merchant", Priority: 5, Enabled: true, Stop: true, When: Condition{Merchant: "shop", MinAccrual: 10}, Then: Action{Bonus: 5, Multiplier: 1.5}}
+	disabled := Rule{ID: 5, Name: "disabled", Then: Action{Bonus: 1}}
+	expired := Rule{ID: 6, Name: "expired", Enabled: true, EndsAt: &ends, Then: Action{Bonus: 1}}
+	all := []Rule{fifth, welcome, weekend, merchant, disabled, expired}
+
+	tests := []struct {
+		name  string
+		facts Facts
+		want  []Result
+	}{
+		{"first weekend order", Facts{ProcessedAt: saturday, Accrual: 50, OrdersInMonth: 1},
+			[]Result{{1, "weekend", 50}, {2, "welcome", 100}}},
+		{"fifth weekday order", Facts{ProcessedAt: saturday.AddDate(0, 0, 2), Accrual: 50, PreviousOrders: 4, OrdersInMonth: 5},
+			[]Result{{3, "fifth", 250}}},
+		{"stop rule", Facts{ProcessedAt: saturday, Accrual: 20, Merchant: "shop", PreviousOrders: 1, OrdersInMonth: 2},
+			[]Result{{4, "merchant", 15}}},
+		{"min accrual not reached", Facts{ProcessedAt: saturday, Accrual: 5, Merchant: "shop", PreviousOrders: 1, OrdersInMonth: 2},
+			[]Result{{1, "weekend", 5}}},
+		{"nothing matches", Facts{ProcessedAt: saturday.AddDate(0, 0, 2), Accrual: 50, PreviousOrders: 1, OrdersInMonth: 2},
+			nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Evaluate(all, tt.facts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadFileExample(t *testing.T) {
+	rules, err := LoadFile("../../../bonus_rules.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Errorf("loaded %d rules, want 3", len(rules))
+	}
+}
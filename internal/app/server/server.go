@@ -1,30 +1,171 @@
 package server
 
 import (
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/devkekops/gophermart/internal/app/client"
 	"github.com/devkekops/gophermart/internal/app/config"
 	"github.com/devkekops/gophermart/internal/app/handlers"
 	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/loyalty"
+	"github.com/devkekops/gophermart/internal/app/rpc"
+	"github.com/devkekops/gophermart/internal/app/rules"
 	"github.com/devkekops/gophermart/internal/app/storage"
 )
 
 func Serve(cfg *config.Config) error {
-	client := client.NewCli(cfg.AccrualSystemAddress, cfg.ClientTimeout)
+	registryCfg := client.RegistryConfig{
+		Default: client.DefaultProvider,
+		Providers: []client.ProviderConfig{{
+			Name:        client.DefaultProvider,
+			Address:     cfg.AccrualSystemAddress,
+			Timeout:     cfg.ClientTimeout,
+			Concurrency: cfg.ClientConcurrency,
+		}},
+	}
+	if cfg.AccrualProvidersFile != "" {
+		var err error
+		registryCfg, err = client.LoadRegistryConfig(cfg.AccrualProvidersFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	providers, err := client.NewRegistry(registryCfg)
+	if err != nil {
+		return err
+	}
+
+	workerCfg := storage.WorkerConfig{
+		InstanceID:   cfg.InstanceID,
+		MaxAttempts:  cfg.MaxAccrualAttempts,
+		PollInterval: time.Duration(cfg.PollInterval) * time.Second,
+		LeaseTTL:     time.Duration(cfg.LeaseTTL) * time.Second,
+		BatchSize:    cfg.AccrualBatchSize,
+		Schedule: storage.ScheduleConfig{
+			Intervals: map[string]time.Duration{
+				storage.NEW:        time.Duration(cfg.PollNewInterval) * time.Second,
+				storage.REGISTERED: time.Duration(cfg.PollRegInterval) * time.Second,
+				storage.PROCESSING: time.Duration(cfg.PollProcInterval) * time.Second,
+			},
+			FailureInterval: time.Duration(cfg.PollFailureInterval) * time.Second,
+			RateLimitDelay:  time.Duration(cfg.PollRateLimitDelay) * time.Second,
+			MaxInterval:     time.Duration(cfg.PollMaxInterval) * time.Second,
+			MaxAge:          time.Duration(cfg.OrderMaxAge) * time.Second,
+		},
+	}
+
+	tiersCfg := loyalty.DefaultConfig()
+	if cfg.LoyaltyTiersFile != "" {
+		tiersCfg, err = loyalty.LoadConfig(cfg.LoyaltyTiersFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	tiers, err := loyalty.NewTiers(tiersCfg)
+	if err != nil {
+		return err
+	}
 
-	repo, err := storage.NewRepoDB(cfg.DatabaseURI, client)
+	var seedRules []rules.Rule
+	if cfg.BonusRulesFile != "" {
+		seedRules, err = rules.LoadFile(cfg.BonusRulesFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	storageCfg := storage.Config{
+		WorkerConfig:   workerCfg,
+		ReversalPolicy: cfg.ReversalPolicy,
+		Recheck: storage.RecheckConfig{
+			Window:   time.Duration(cfg.RecheckWindow) * time.Second,
+			Interval: time.Duration(cfg.RecheckInterval) * time.Second,
+		},
+		Expiry: storage.ExpiryConfig{
+			Months:   cfg.PointsTTLMonths,
+			Soon:     time.Duration(cfg.PointsExpiringSoon) * time.Second,
+			Interval: time.Duration(cfg.PointsExpiryInterval) * time.Second,
+		},
+		Transfers: storage.TransferLimits{
+			DailySum:       cfg.TransferDailyLimit,
+			DailyCount:     cfg.TransferDailyCount,
+			PendingTTL:     time.Duration(cfg.TransferPendingTTL) * time.Second,
+			ExpiryInterval: time.Duration(cfg.TransferExpiry) * time.Second,
+		},
+		Tiers:              tiers,
+		TierRecalcInterval: time.Duration(cfg.TierRecalcInterval) * time.Second,
+		Referrals: storage.ReferralConfig{
+			ReferrerBonus: cfg.ReferrerBonus,
+			RefereeBonus:  cfg.RefereeBonus,
+			MonthlyLimit:  cfg.ReferralMonthlyLimit,
+			MinAccrual:    cfg.ReferralMinAccrual,
+			SweepInterval: time.Duration(cfg.ReferralSweep) * time.Second,
+		},
+		Withdrawals: storage.WithdrawalLimits{
+			MaxSum:         cfg.WithdrawalMaxSum,
+			DailySum:       cfg.WithdrawalDailyLimit,
+			DailyCount:     cfg.WithdrawalDailyCount,
+			MinAccountAge:  time.Duration(cfg.WithdrawalMinAge) * time.Second,
+			VelocityCount:  cfg.WithdrawalVelocity,
+			VelocityWindow: time.Duration(cfg.WithdrawalWindow) * time.Second,
+			ReviewSum:      cfg.WithdrawalReviewSum,
+		},
+		Holds: storage.HoldConfig{
+			TTL:      time.Duration(cfg.HoldTTL) * time.Second,
+			Interval: time.Duration(cfg.HoldExpiryInterval) * time.Second,
+		},
+		Idempotency: storage.IdempotencyConfig{
+			TTL:           time.Duration(cfg.IdempotencyTTL) * time.Second,
+			LockTimeout:   time.Duration(cfg.IdempotencyLock) * time.Second,
+			PurgeInterval: time.Duration(cfg.IdempotencyPurge) * time.Second,
+		},
+	}
+
+	repo, err := storage.NewRepoDB(cfg.DatabaseURI, providers, storageCfg)
 	if err != nil {
-		logger.Logger.Err(err).Msg("")
+		return err
 	}
 	defer repo.Close()
 
-	var baseHandler = handlers.NewBaseHandler(repo, cfg.SecretKey)
+	if len(seedRules) > 0 {
+		seeded, err := repo.SeedRules(seedRules)
+		if err != nil {
+			return err
+		}
+		if seeded > 0 {
+			logger.Logger.Info().Msgf("seeded %d bonus rules from %s", seeded, cfg.BonusRulesFile)
+		}
+	}
+
+	var baseHandler = handlers.NewBaseHandler(repo, cfg)
 
 	server := &http.Server{
 		Addr:    cfg.RunAddress,
 		Handler: baseHandler,
 	}
 
-	return server.ListenAndServe()
+	if cfg.GRPCAddress == "" {
+		return server.ListenAndServe()
+	}
+
+	listener, err := net.Listen("tcp", cfg.GRPCAddress)
+	if err != nil {
+		return err
+	}
+	grpcServer := rpc.NewServer(repo, cfg.SecretKey)
+	defer grpcServer.Stop()
+
+	errc := make(chan error, 2)
+	go func() {
+		errc <- grpcServer.Serve(listener)
+	}()
+	go func() {
+		errc <- server.ListenAndServe()
+	}()
+
+	return <-errc
 }
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+// Политики списания баллов при уменьшении или отзыве начисления.
+const (
+	// ReversalNegative списывает всю разницу, баланс может уйти в минус.
+	ReversalNegative = "negative"
+	// ReversalDebt списывает не больше текущего баланса, остаток записывается в долг,
+	// который гасится из следующих начислений.
+	ReversalDebt = "debt"
+)
+
+const (
+	AdjustedByProvider = "provider"
+	AdjustedByAdmin    = "admin"
+)
+
+const (
+	reasonRecalculated = "recalculated by accrual system"
+	reasonRevoked      = "revoked by accrual system"
+)
+
+// RecheckConfig - повторный опрос уже рассчитанных заказов. Возврат заказа система расчёта сообщает
+// webhook-ом (ApplyAccrual), а если webhook не настроен или вызов потерялся, уменьшение или отзыв
+// начисления находит recheckLoop: каждый заказ, рассчитанный не раньше Window назад, опрашивается раз в Interval.
+// Window 0 отключает повторный опрос, тогда возвраты приходят только через webhook и API администратора.
+type RecheckConfig struct {
+	Window   time.Duration
+	Interval time.Duration
+}
+
+func validReversalPolicy(policy string) bool {
+	return policy == ReversalNegative || policy == ReversalDebt
+}
+
+// AdjustAccrual меняет начисление системы расчёта по уже рассчитанному заказу на accrual и проводит разницу по счёту.
+// Коэффициент уровня берётся тот же, с которым заказ был начислен.
+// Первоначальное начисление сохраняется в original_accrual, чтобы история показывала его и корректировки отдельно.
+func (r *RepoDB) AdjustAccrual(orderID string, accrual float64, reason string, adjustedBy string) error {
+	if !validAccrual(accrual) {
+		return fmt.Errorf("%w: %v", ErrInvalidAccrual, accrual)
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	var userID int64
+	var status string
+	var current, multiplier float64
+	queryGetOrder := `SELECT user_id, status, accrual, COALESCE(multiplier, 1) FROM orders WHERE order_id = ($1) FOR UPDATE`
+	err = tx.QueryRow(queryGetOrder, orderID).Scan(&userID, &status, &current, &multiplier)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	}
+	if status != PROCESSED {
+		return ErrOrderNotProcessed
+	}
+
+	credited := roundPoints(accrual * multiplier)
+	delta := roundPoints(credited - current)
+	if delta == 0 {
+		return nil
+	}
+
+	queryUpdateAccrual := `UPDATE orders SET original_accrual = COALESCE(original_accrual, accrual), accrual = ($1), base_accrual = ($2) WHERE order_id = ($3)`
+	_, err = tx.Exec(queryUpdateAccrual, credited, accrual, orderID)
+	if err != nil {
+		return err
+	}
+
+	uid := strconv.FormatInt(userID, 10)
+	var debt float64
+	if delta > 0 {
+		err = r.credit(tx, uid, orderID, delta)
+	} else {
+		debt, err = r.debit(tx, uid, orderID, -delta)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = r.updateTier(tx, uid)
+	if err != nil {
+		return err
+	}
+
+	queryAddAdjustment := `INSERT INTO accrual_adjustments (order_id, user_id, accrual, delta, debt, reason, adjusted_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = tx.Exec(queryAddAdjustment, orderID, userID, credited, delta, debt, reason, adjustedBy, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = r.notifyBalance(tx, uid)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// orderAdjustments подгружает корректировки к странице заказов.
+func (r *RepoDB) orderAdjustments(orders []entity.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	orderIDs := make([]string, 0, len(orders))
+	byID := make(map[string]int, len(orders))
+	for i, order := range orders {
+		orderIDs = append(orderIDs, order.OrderID)
+		byID[order.OrderID] = i
+	}
+
+	var adjustments []entity.Adjustment
+	queryGetAdjustments := `SELECT order_id, accrual, delta, debt, reason, created_at FROM accrual_adjustments
+		WHERE order_id = ANY($1) ORDER BY created_at ASC, id ASC`
+	err := r.db.Select(&adjustments, queryGetAdjustments, orderIDs)
+	if err != nil {
+		return fmt.Errorf("load adjustments: %w", err)
+	}
+
+	for _, adjustment := range adjustments {
+		i := byID[adjustment.OrderID]
+		orders[i].Adjustments = append(orders[i].Adjustments, adjustment)
+	}
+	return nil
+}
+
+func (r *RepoDB) recheckLoop() {
+	if r.cfg.Recheck.Window <= 0 {
+		return
+	}
+	interval := r.cfg.Recheck.Interval
+	if interval <= 0 {
+		interval = 6 * time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := r.recheckOrders(time.Now(), interval); err != nil {
+			logger.Logger.Err(err).Msg("")
+		}
+	}
+}
+
+// recheckOrders опрашивает рассчитанные заказы, которые не проверялись дольше interval, пачками по BatchSize
+// и проводит изменившиеся начисления через ApplyAccrual. Пачки забираются с SKIP LOCKED и сразу помечаются,
+// поэтому реплики не опрашивают один заказ дважды за проход.
+func (r *RepoDB) recheckOrders(now time.Time, interval time.Duration) error {
+	batchSize := r.cfg.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	for {
+		batches, claimed, err := r.claimRecheck(now, interval, batchSize)
+		if err != nil {
+			return err
+		}
+		for providerName, numbers := range batches {
+			_, provider := r.provider(providerName)
+			responses, err := provider.GetAccrualInfoBatch(numbers)
+			if err != nil {
+				return err
+			}
+			for i, resp := range responses {
+				if resp.Err != nil {
+					logger.Logger.Err(resp.Err).Msgf("recheck order %s", numbers[i])
+					continue
+				}
+				if resp.StatusCode == http.StatusTooManyRequests {
+					// остальные заказы пачки проверятся в следующий проход
+					return nil
+				}
+				if resp.StatusCode != http.StatusOK || (resp.Status != PROCESSED && resp.Status != INVALID) {
+					continue
+				}
+				if err := r.ApplyAccrual(numbers[i], resp.Status, resp.Accrual); err != nil {
+					logger.Logger.Err(err).Msgf("recheck order %s", numbers[i])
+				}
+			}
+		}
+		if claimed < batchSize {
+			return nil
+		}
+	}
+}
+
+// claimRecheck помечает до limit заказов для повторного опроса и группирует их номера по провайдеру.
+func (r *RepoDB) claimRecheck(now time.Time, interval time.Duration, limit int) (map[string][]string, int, error) {
+	queryClaimRecheck := `UPDATE orders SET rechecked_at = ($1)
+		WHERE order_id IN (
+			SELECT order_id FROM orders
+			WHERE status = ($2) AND processed_at >= ($3) AND (rechecked_at IS NULL OR rechecked_at <= ($4))
+			ORDER BY rechecked_at ASC NULLS FIRST, processed_at ASC
+			LIMIT ($5)
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING order_id, COALESCE(provider, '')`
+	rows, err := r.db.Query(queryClaimRecheck, now, PROCESSED, now.Add(-r.cfg.Recheck.Window), now.Add(-interval), limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	batches := make(map[string][]string)
+	claimed := 0
+	for rows.Next() {
+		var orderID, provider string
+		if err := rows.Scan(&orderID, &provider); err != nil {
+			return nil, 0, err
+		}
+		batches[provider] = append(batches[provider], orderID)
+		claimed++
+	}
+	return batches, claimed, rows.Err()
+}
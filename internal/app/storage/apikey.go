@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+)
+
+const apiKeyPrefix = "gm_"
+
+// Ключи API хранятся только в виде sha256, открытый ключ отдаётся один раз при создании.
+
+func newAPIKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + hex.EncodeToString(buf), nil
+}
+
+func apiKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *RepoDB) CreateAPIKey(userID string, name string) (entity.APIKey, error) {
+	apiKey := entity.APIKey{Name: name}
+	key, err := newAPIKey()
+	if err != nil {
+		return apiKey, err
+	}
+
+	now := time.Now()
+	queryAddKey := `INSERT INTO api_keys (user_id, key_hash, name, created_at) VALUES ($1, $2, $3, $4) RETURNING id`
+	err = r.db.QueryRow(queryAddKey, userID, apiKeyHash(key), name, now).Scan(&apiKey.ID)
+	if err != nil {
+		return apiKey, err
+	}
+
+	apiKey.Key = key
+	apiKey.CreatedAt = now.Format(time.RFC3339)
+	return apiKey, nil
+}
+
+func (r *RepoDB) GetAPIKeys(userID string) ([]entity.APIKey, error) {
+	var keys []entity.APIKey
+	queryGetKeys := `SELECT id, name, created_at, last_used_at FROM api_keys WHERE user_id = ($1) AND revoked_at IS NULL ORDER BY id ASC`
+	err := r.db.Select(&keys, queryGetKeys, userID)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *RepoDB) RevokeAPIKey(userID string, keyID int64) error {
+	queryRevokeKey := `UPDATE api_keys SET revoked_at = ($1) WHERE id = ($2) AND user_id = ($3) AND revoked_at IS NULL`
+	res, err := r.db.Exec(queryRevokeKey, time.Now(), keyID, userID)
+	if err != nil {
+		return err
+	}
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey возвращает владельца действующего ключа и отмечает время его использования.
+func (r *RepoDB) AuthenticateAPIKey(key string) (string, error) {
+	var userID string
+	queryUseKey := `UPDATE api_keys SET last_used_at = ($1) WHERE key_hash = ($2) AND revoked_at IS NULL RETURNING user_id::text`
+	err := r.db.QueryRow(queryUseKey, time.Now(), apiKeyHash(key)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrInvalidAPIKey
+		}
+		return "", err
+	}
+	return userID, nil
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	at := time.Date(2024, 3, 1, 10, 20, 30, 123456000, time.UTC)
+	cursor := encodeCursor(at.Format(time.RFC3339Nano), "12345|678")
+
+	gotAt, gotID, err := decodeCursor(cursor)
+	if err != nil {
+		t.Fatalf("decodeCursor(%q) error = %v", cursor, err)
+	}
+	if !gotAt.Equal(at) {
+		t.Errorf("at = %v, want %v", gotAt, at)
+	}
+	if gotID != "12345|678" {
+		t.Errorf("id = %q, want %q", gotID, "12345|678")
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "@@@"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte("2024-03-01T10:20:30Z|1"))},
+		{"no separator", encode("2024-03-01T10:20:30Z")},
+		{"bad time", encode("yesterday|1")},
+		{"date only", encode("2024-03-01|1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCursor(tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor(%q) error = %v, want %v", tt.cursor, err, ErrInvalidCursor)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/events"
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+// События публикуются через NOTIFY и доходят до шины каждой реплики через LISTEN, поэтому подписчик
+// получает их независимо от того, какая реплика изменила заказ. NOTIFY в транзакции доставляется
+// только после её фиксации.
+
+const (
+	eventsChannel       = "gophermart_events"
+	listenRetryInterval = 5 * time.Second
+)
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func notify(db execer, userID string, kind string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(events.Event{UserID: userID, Type: kind, Data: raw})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`SELECT pg_notify($1, $2)`, eventsChannel, string(payload))
+	return err
+}
+
+func notifyOrder(db execer, userID string, order entity.OrderUpdate) error {
+	return notify(db, userID, events.OrderEvent, order)
+}
+
+// notifyBalance публикует баланс пользователя, каким он станет после фиксации tx,
+// в том же виде, что и GetBalance, чтобы событие могло целиком заменить состояние клиента.
+func (r *RepoDB) notifyBalance(tx *sql.Tx, userID string) error {
+	var balance entity.Balance
+	queryGetBalance := `SELECT current, held, withdrawn, debt FROM users WHERE user_id = ($1)`
+	err := tx.QueryRow(queryGetBalance, userID).Scan(&balance.Current, &balance.Held, &balance.Withdrawn, &balance.Debt)
+	if err != nil {
+		return err
+	}
+	balance.Available = balance.Current
+
+	balance.ExpiringSoon, err = r.expiringSoon(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	return notify(tx, userID, events.BalanceEvent, balance)
+}
+
+func (r *RepoDB) Subscribe(userID string) (<-chan events.Event, func()) {
+	return r.events.Subscribe(userID)
+}
+
+// listen держит отдельное соединение с LISTEN и передаёт уведомления в шину, переподключаясь при обрыве.
+// События, отправленные во время переподключения, теряются: клиенты получают актуальное состояние при подписке.
+func (r *RepoDB) listen(databaseURI string) {
+	for {
+		err := r.listenOnce(databaseURI)
+		logger.Logger.Err(err).Msg("events listener disconnected")
+		time.Sleep(listenRetryInterval)
+	}
+}
+
+func (r *RepoDB) listenOnce(databaseURI string) error {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, databaseURI)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+
+	_, err = conn.Exec(ctx, "LISTEN "+eventsChannel)
+	if err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var ev events.Event
+		if err := json.Unmarshal([]byte(notification.Payload), &ev); err != nil {
+			logger.Logger.Err(err).Msg("")
+			continue
+		}
+		r.events.Publish(ev)
+	}
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"math"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+const expiryBatchSize = 1000
+
+// ExpiryConfig задаёт срок жизни баллов. Months 0 - новые партии не сгорают.
+// Soon - за сколько до сгорания баллы показываются в балансе, Interval - период проверки сгоревших партий.
+type ExpiryConfig struct {
+	Months   int
+	Soon     time.Duration
+	Interval time.Duration
+}
+
+func (r *RepoDB) expireLoop() {
+	interval := r.cfg.Expiry.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := r.expireLots(time.Now()); err != nil {
+			logger.Logger.Err(err).Msg("")
+		}
+	}
+}
+
+// expireLots списывает остатки партий, срок которых истёк к now. Пользователи обрабатываются
+// по одному в отдельных транзакциях под блокировкой строки пользователя, поэтому реплики не спишут партию дважды.
+// Пачки по expiryBatchSize пользователей берутся, пока очередная не окажется неполной, чтобы накопившиеся
+// партии сгорели за один проход.
+func (r *RepoDB) expireLots(now time.Time) error {
+	queryGetUsers := `SELECT DISTINCT user_id::text FROM point_lots WHERE expires_at <= ($1) AND remaining > 0 LIMIT ($2)`
+	for {
+		var userIDs []string
+		err := r.db.Select(&userIDs, queryGetUsers, now, expiryBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, userID := range userIDs {
+			if err := r.expireUserLots(userID, now); err != nil {
+				return err
+			}
+		}
+		if len(userIDs) < expiryBatchSize {
+			return nil
+		}
+	}
+}
+
+// expireUserLots не уводит баланс в минус: если на счёте меньше, чем в сгоревших партиях
+// (например, после списания по ReversalNegative), сгорает только то, что есть.
+func (r *RepoDB) expireUserLots(userID string, now time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	var current float64
+	queryGetCurrent := `SELECT current FROM users WHERE user_id = ($1) FOR UPDATE`
+	err = tx.QueryRow(queryGetCurrent, userID).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	queryGetExpiredLots := `SELECT id, order_id, remaining FROM point_lots WHERE user_id = ($1) AND expires_at <= ($2) AND remaining > 0
+		ORDER BY expires_at ASC, id ASC FOR UPDATE`
+	lots, err := selectLots(tx, queryGetExpiredLots, userID, now)
+	if err != nil {
+		return err
+	}
+
+	available := math.Max(current, 0)
+	var orderIDs []string
+	expired := make(map[string]float64)
+	for _, l := range lots {
+		amount := math.Min(l.remaining, available)
+		available = roundPoints(available - amount)
+
+		queryExpireLot := `UPDATE point_lots SET remaining = 0, expired_at = ($1) WHERE id = ($2)`
+		_, err = tx.Exec(queryExpireLot, now, l.id)
+		if err != nil {
+			return err
+		}
+
+		if amount > 0 {
+			if _, ok := expired[l.orderID]; !ok {
+				orderIDs = append(orderIDs, l.orderID)
+			}
+			expired[l.orderID] = roundPoints(expired[l.orderID] + amount)
+		}
+	}
+
+	var total float64
+	for _, orderID := range orderIDs {
+		queryAddExpiration := `INSERT INTO point_expirations (user_id, order_id, amount, expired_at) VALUES ($1, $2, $3, $4)`
+		_, err = tx.Exec(queryAddExpiration, userID, orderID, expired[orderID], now)
+		if err != nil {
+			return err
+		}
+		total += expired[orderID]
+	}
+
+	if total > 0 {
+		queryExpirePoints := `UPDATE users SET current = current - ($1) WHERE user_id = ($2)`
+		_, err = tx.Exec(queryExpirePoints, roundPoints(total), userID)
+		if err != nil {
+			return err
+		}
+
+		err = r.notifyBalance(tx, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// expiringSoon группирует по дням баллы, которые сгорят в ближайшие Expiry.Soon.
+// В транзакции учитываются её ещё не зафиксированные изменения партий.
+func (r *RepoDB) expiringSoon(db queryer, userID string) ([]entity.ExpiringPoints, error) {
+	if r.cfg.Expiry.Soon <= 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	queryGetExpiring := `SELECT to_char(expires_at, 'YYYY-MM-DD') AS expires_on, SUM(remaining) AS amount FROM point_lots
+		WHERE user_id = ($1) AND remaining > 0 AND expires_at > ($2) AND expires_at <= ($3)
+		GROUP BY expires_on ORDER BY expires_on ASC`
+	rows, err := db.Query(queryGetExpiring, userID, now, now.Add(r.cfg.Expiry.Soon))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []entity.ExpiringPoints
+	for rows.Next() {
+		var p entity.ExpiringPoints
+		if err := rows.Scan(&p.ExpiresOn, &p.Amount); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+)
+
+const (
+	HistoryAccrual        = "ACCRUAL"
+	HistoryWithdrawal     = "WITHDRAWAL"
+	HistoryRefund         = "REFUND"
+	HistoryAdjustment     = "ADJUSTMENT"
+	HistoryExpiry         = "EXPIRY"
+	HistoryTransferOut    = "TRANSFER_OUT"
+	HistoryTransferIn     = "TRANSFER_IN"
+	HistoryTransferReturn = "TRANSFER_RETURN"
+	HistoryBonus          = "BONUS"
+	HistoryReferral       = "REFERRAL"
+	HistoryVoucher        = "VOUCHER"
+	HistoryHold           = "HOLD"
+	HistoryHoldRelease    = "HOLD_RELEASE"
+)
+
+// historyView - все движения по счёту пользователя со знаком суммы, на нём строятся история и выписка.
+// Начисление датируется моментом расчёта, для старых заказов без processed_at - моментом загрузки.
+// Начисление показывается в первоначальном размере, последующие изменения - отдельными корректировками.
+// У переводов в reason - логин второй стороны, у бонусов - название правила акции.
+// ref уникален для записи пользователя и вместе с at служит ключом пагинации.
+var historyView = `
+CREATE OR REPLACE VIEW balance_history AS
+	SELECT user_id, COALESCE(processed_at, uploaded_at) AS at, 'ACCRUAL'::text AS kind, order_id, COALESCE(original_accrual, accrual) AS amount, ''::text AS reason,
+			'ACCRUAL:' || order_id AS ref
+		FROM orders WHERE status = 'PROCESSED' AND COALESCE(original_accrual, accrual) <> 0
+	UNION ALL
+	SELECT user_id, processed_at, 'WITHDRAWAL', order_id, -sum, '', 'WITHDRAWAL:' || order_id
+		FROM withdrawals
+	UNION ALL
+	SELECT user_id, refunded_at, 'REFUND', order_id, sum, COALESCE(refund_reason, ''), 'REFUND:' || order_id
+		FROM withdrawals WHERE refunded_at IS NOT NULL
+	UNION ALL
+	SELECT user_id, created_at, 'ADJUSTMENT', order_id, delta, reason, 'ADJUSTMENT:' || order_id || ':' || id
+		FROM accrual_adjustments
+	UNION ALL
+	SELECT user_id, expired_at, 'EXPIRY', order_id, -amount, '', 'EXPIRY:' || order_id || ':' || id
+		FROM point_expirations
+	UNION ALL
+	SELECT t.sender_id, t.created_at, 'TRANSFER_OUT', 'transfer-' || t.id, -t.sum, u.login, 'TRANSFER_OUT:transfer-' || t.id
+		FROM transfers t JOIN users u ON u.user_id = t.recipient_id
+	UNION ALL
+	SELECT t.sender_id, t.resolved_at, 'TRANSFER_RETURN', 'transfer-' || t.id, t.sum, u.login, 'TRANSFER_RETURN:transfer-' || t.id
+		FROM transfers t JOIN users u ON u.user_id = t.recipient_id WHERE t.status IN ('DECLINED', 'CANCELED', 'EXPIRED')
+	UNION ALL
+	SELECT t.recipient_id, t.resolved_at, 'TRANSFER_IN', 'transfer-' || t.id, t.sum, u.login, 'TRANSFER_IN:transfer-' || t.id
+		FROM transfers t JOIN users u ON u.user_id = t.sender_id WHERE t.status = 'COMPLETED'
+	UNION ALL
+	SELECT user_id, fired_at, 'BONUS', order_id, bonus, rule_name, 'BONUS:' || order_id || ':' || rule_id
+		FROM rule_firings WHERE bonus <> 0
+	UNION ALL
+	SELECT referrer_id, resolved_at, 'REFERRAL', 'referral-' || referee_id, referrer_bonus, 'referrer', 'REFERRAL:referrer:' || referee_id
+		FROM referrals WHERE status = 'REWARDED' AND referrer_bonus <> 0
+	UNION ALL
+	SELECT referee_id, resolved_at, 'REFERRAL', 'referral-' || referee_id, referee_bonus, 'referee', 'REFERRAL:referee:' || referee_id
+		FROM referrals WHERE status = 'REWARDED' AND referee_bonus <> 0
+	UNION ALL
+	SELECT user_id, redeemed_at, 'VOUCHER', 'voucher-' || batch_id, value, '', 'VOUCHER:' || id
+		FROM voucher_redemptions
+	UNION ALL
+	SELECT user_id, created_at, 'HOLD', order_id, -sum, '', 'HOLD:' || id
+		FROM holds WHERE status <> 'CAPTURED'
+	UNION ALL
+	SELECT user_id, resolved_at, 'HOLD_RELEASE', order_id, sum, status, 'HOLD_RELEASE:' || id
+		FROM holds WHERE status IN ('VOIDED', 'EXPIRED');`
+
+// HistoryQuery - фильтры и keyset-пагинация истории движений по счёту.
+// Нулевые значения полей означают отсутствие фильтра, Limit 0 - вся история.
+type HistoryQuery struct {
+	Kinds  []string
+	From   time.Time
+	To     time.Time
+	Desc   bool
+	Limit  int
+	Cursor string
+}
+
+func (r *RepoDB) GetHistory(userID string, q HistoryQuery) ([]entity.HistoryEntry, string, error) {
+	var entries []entity.HistoryEntry
+	queryGetHistory := "SELECT at, kind, order_id, amount, reason, ref FROM balance_history WHERE user_id = ($1)"
+	args := []interface{}{userID}
+
+	if len(q.Kinds) > 0 {
+		args = append(args, q.Kinds)
+		queryGetHistory += fmt.Sprintf(" AND kind = ANY($%d)", len(args))
+	}
+	if !q.From.IsZero() {
+		args = append(args, q.From)
+		queryGetHistory += fmt.Sprintf(" AND at >= ($%d)", len(args))
+	}
+	if !q.To.IsZero() {
+		args = append(args, q.To)
+		queryGetHistory += fmt.Sprintf(" AND at < ($%d)", len(args))
+	}
+
+	direction, cmp := "ASC", ">"
+	if q.Desc {
+		direction, cmp = "DESC", "<"
+	}
+	if q.Cursor != "" {
+		at, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, at, id)
+		queryGetHistory += fmt.Sprintf(" AND (at, ref) %s ($%d, $%d)", cmp, len(args)-1, len(args))
+	}
+
+	queryGetHistory += fmt.Sprintf(" ORDER BY at %s, ref %s", direction, direction)
+	if q.Limit > 0 {
+		args = append(args, q.Limit+1)
+		queryGetHistory += fmt.Sprintf(" LIMIT ($%d)", len(args))
+	}
+
+	err := r.db.Select(&entries, queryGetHistory, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if q.Limit > 0 && len(entries) > q.Limit {
+		entries = entries[:q.Limit]
+		last := entries[len(entries)-1]
+		next = encodeCursor(last.Date, last.Ref)
+	}
+
+	return entries, next, nil
+}
@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+const (
+	HoldActive   = "HELD"
+	HoldCaptured = "CAPTURED"
+	HoldVoided   = "VOIDED"
+	HoldExpired  = "EXPIRED"
+)
+
+const holdExpiryBatch = 1000
+
+// HoldConfig - время жизни блокировки баллов под заказ и период освобождения истёкших блокировок.
+type HoldConfig struct {
+	TTL      time.Duration
+	Interval time.Duration
+}
+
+// Блокировка (authorize) сразу списывает баллы с current в held и расходует партии, поэтому заблокированные баллы
+// не сгорают и не тратятся другими списаниями. Capture превращает блокировку в обычное списание,
+// void и истечение срока возвращают баллы так же, как возврат списания.
+
+func holdRef(id int64) string {
+	return "hold-" + strconv.FormatInt(id, 10)
+}
+
+type hold struct {
+	id           int64
+	userID       string
+	sum          float64
+	expiresAt    time.Time
+	reviewReason string
+}
+
+// AuthorizeWithdrawal блокирует sum в счёт заказа orderID. Лимиты списаний проверяются здесь,
+// а решение о ручной проверке запоминается и применяется при capture.
+func (r *RepoDB) AuthorizeWithdrawal(orderID string, userID string, sum float64) (entity.Hold, error) {
+	h := entity.Hold{OrderID: orderID, Sum: sum, Status: HoldActive}
+	if !validSum(sum) {
+		return h, ErrInvalidSum
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return h, err
+	}
+	defer rollback(tx)
+
+	var newBalance float64
+	var registeredAt sql.NullTime
+	queryHoldBalance := `UPDATE users SET current = current - ($1), held = held + ($1) WHERE user_id = ($2) RETURNING current, created_at`
+	err = tx.QueryRow(queryHoldBalance, sum, userID).Scan(&newBalance, &registeredAt)
+	if err != nil {
+		return h, err
+	}
+	if newBalance < 0 {
+		return h, ErrInsufficientFunds
+	}
+
+	var paid bool
+	queryGetWithdrawal := `SELECT EXISTS(SELECT 1 FROM withdrawals WHERE order_id = ($1) AND refunded_at IS NULL)`
+	err = tx.QueryRow(queryGetWithdrawal, orderID).Scan(&paid)
+	if err != nil {
+		return h, err
+	}
+	if paid {
+		return h, ErrWithdrawalExists
+	}
+
+	now := time.Now().Truncate(time.Second)
+	reviewReason, err := r.checkWithdrawal(tx, userID, sum, registeredAt, now)
+	if err != nil {
+		return h, err
+	}
+
+	var id int64
+	expiresAt := now.Add(r.cfg.Holds.TTL)
+	queryAddHold := `INSERT INTO holds (order_id, user_id, sum, status, review_reason, created_at, expires_at) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7)
+		RETURNING id`
+	err = tx.QueryRow(queryAddHold, orderID, userID, sum, HoldActive, reviewReason, now, expiresAt).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return h, ErrWithdrawalExists
+		}
+		return h, err
+	}
+
+	err = consume(tx, userID, ConsumedByHold, holdRef(id), sum)
+	if err != nil {
+		return h, err
+	}
+
+	err = r.notifyBalance(tx, userID)
+	if err != nil {
+		return h, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return h, err
+	}
+
+	h.CreatedAt = now.Format(time.RFC3339)
+	h.ExpiresAt = expiresAt.Format(time.RFC3339)
+	return h, nil
+}
+
+// lockHold блокирует активную блокировку заказа. Пустой userID - без проверки владельца.
+func lockHold(tx *sql.Tx, orderID string, userID string) (hold, error) {
+	var h hold
+	queryGetHold := `SELECT id, user_id::text, sum, expires_at, COALESCE(review_reason, '') FROM holds WHERE order_id = ($1) AND status = ($2) FOR UPDATE`
+	err := tx.QueryRow(queryGetHold, orderID, HoldActive).Scan(&h.id, &h.userID, &h.sum, &h.expiresAt, &h.reviewReason)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return h, ErrHoldNotFound
+		}
+		return h, err
+	}
+	if userID != "" && h.userID != userID {
+		return h, ErrHoldNotFound
+	}
+	return h, nil
+}
+
+// release возвращает заблокированные баллы на счёт и закрывает блокировку со статусом status.
+func release(tx *sql.Tx, h hold, status string, now time.Time) error {
+	queryReleaseHeld := `UPDATE users SET held = held - ($1) WHERE user_id = ($2)`
+	_, err := tx.Exec(queryReleaseHeld, h.sum, h.userID)
+	if err != nil {
+		return err
+	}
+
+	err = restore(tx, h.userID, ConsumedByHold, holdRef(h.id), h.sum)
+	if err != nil {
+		return err
+	}
+
+	queryCloseHold := `UPDATE holds SET status = ($1), resolved_at = ($2) WHERE id = ($3)`
+	_, err = tx.Exec(queryCloseHold, status, now, h.id)
+	return err
+}
+
+// CaptureWithdrawal списывает sum из блокировки заказа (0 - всю сумму), остаток возвращается на счёт.
+// Возвращает статус созданного списания.
+func (r *RepoDB) CaptureWithdrawal(orderID string, userID string, sum float64) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer rollback(tx)
+
+	h, err := lockHold(tx, orderID, userID)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().Truncate(time.Second)
+	if !h.expiresAt.After(now) {
+		return "", ErrHoldExpired
+	}
+	if sum == 0 {
+		sum = h.sum
+	}
+	if !validSum(sum) {
+		return "", ErrInvalidSum
+	}
+	if sum > h.sum {
+		return "", ErrCaptureExceedsHold
+	}
+
+	// захваченная часть переходит из held в withdrawn, не проходя через счёт, поэтому долг её не съедает;
+	// на счёт возвращается только незахваченный остаток
+	queryCaptureHeld := `UPDATE users SET held = held - ($1), withdrawn = withdrawn + ($2) WHERE user_id = ($3)`
+	_, err = tx.Exec(queryCaptureHeld, h.sum, sum, h.userID)
+	if err != nil {
+		return "", err
+	}
+
+	err = retag(tx, ConsumedByHold, holdRef(h.id), ConsumedByWithdrawal, orderID, sum)
+	if err != nil {
+		return "", err
+	}
+	err = restore(tx, h.userID, ConsumedByHold, holdRef(h.id), roundPoints(h.sum-sum))
+	if err != nil {
+		return "", err
+	}
+
+	queryCaptureHold := `UPDATE holds SET status = ($1), resolved_at = ($2), captured = ($3) WHERE id = ($4)`
+	_, err = tx.Exec(queryCaptureHold, HoldCaptured, now, sum, h.id)
+	if err != nil {
+		return "", err
+	}
+
+	status := WithdrawalProcessed
+	if h.reviewReason != "" {
+		status = WithdrawalReview
+	}
+	err = addWithdrawal(tx, orderID, h.userID, sum, status, h.reviewReason, now)
+	if err != nil {
+		return "", err
+	}
+
+	err = r.notifyBalance(tx, h.userID)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return status, nil
+}
+
+func (r *RepoDB) VoidWithdrawal(orderID string, userID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	h, err := lockHold(tx, orderID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = release(tx, h, HoldVoided, time.Now().Truncate(time.Second))
+	if err != nil {
+		return err
+	}
+
+	err = r.notifyBalance(tx, h.userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *RepoDB) GetHolds(userID string) ([]entity.Hold, error) {
+	var holds []entity.Hold
+	queryGetHolds := `SELECT order_id, sum, captured, status, created_at, expires_at, resolved_at FROM holds
+		WHERE user_id = ($1) ORDER BY created_at DESC, id DESC`
+	err := r.db.Select(&holds, queryGetHolds, userID)
+	if err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+func (r *RepoDB) holdLoop() {
+	interval := r.cfg.Holds.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := r.expireHolds(time.Now()); err != nil {
+			logger.Logger.Err(err).Msg("")
+		}
+	}
+}
+
+// expireHolds освобождает истёкшие блокировки, каждую в своей транзакции;
+// блокировки, которые в этот момент захватывает или отменяет пользователь, пропускаются.
+func (r *RepoDB) expireHolds(now time.Time) error {
+	var orderIDs []string
+	queryGetExpired := `SELECT order_id FROM holds WHERE status = ($1) AND expires_at <= ($2) LIMIT ($3)`
+	err := r.db.Select(&orderIDs, queryGetExpired, HoldActive, now, holdExpiryBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, orderID := range orderIDs {
+		if err := r.expireHold(orderID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RepoDB) expireHold(orderID string, now time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	var h hold
+	queryGetHold := `SELECT id, user_id::text, sum FROM holds WHERE order_id = ($1) AND status = ($2) AND expires_at <= ($3) FOR UPDATE SKIP LOCKED`
+	err = tx.QueryRow(queryGetHold, orderID, HoldActive, now).Scan(&h.id, &h.userID, &h.sum)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	err = release(tx, h, HoldExpired, now)
+	if err != nil {
+		return err
+	}
+
+	err = r.notifyBalance(tx, h.userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
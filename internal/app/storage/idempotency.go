@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+type IdempotencyConfig struct {
+	// TTL - сколько хранится ответ по ключу; ключи старше удаляет idempotencyLoop.
+	TTL time.Duration
+	// LockTimeout - через сколько незавершённую резервацию ключа может перехватить повтор запроса.
+	LockTimeout   time.Duration
+	PurgeInterval time.Duration
+}
+
+func (r *RepoDB) idempotencyLoop() {
+	interval := r.cfg.Idempotency.PurgeInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := r.purgeIdempotencyKeys(time.Now()); err != nil {
+			logger.Logger.Err(err).Msg("")
+		}
+	}
+}
+
+// purgeIdempotencyKeys удаляет ключи, истёкшие к now: повтор с таким ключом всё равно выполнился бы заново.
+func (r *RepoDB) purgeIdempotencyKeys(now time.Time) error {
+	ttl := r.cfg.Idempotency.TTL
+	if ttl <= 0 {
+		return nil
+	}
+	queryPurgeKeys := `DELETE FROM idempotency_keys WHERE created_at < ($1)`
+	_, err := r.db.Exec(queryPurgeKeys, now.Add(-ttl))
+	return err
+}
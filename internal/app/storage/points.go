@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"database/sql"
+	"math"
+	"time"
+)
+
+// Баллы на счёте учитываются партиями (point_lots): каждое пополнение создаёт партию со своим сроком сгорания,
+// списания расходуют партии по FIFO - первыми те, что сгорают раньше. Баланс, накопленный до появления партий,
+// партиями не покрыт и не сгорает.
+
+const (
+	ConsumedByWithdrawal = "WITHDRAWAL"
+	ConsumedByAdjustment = "ADJUSTMENT"
+	ConsumedByTransfer   = "TRANSFER"
+	ConsumedByHold       = "HOLD"
+)
+
+type lot struct {
+	id        int64
+	orderID   string
+	remaining float64
+}
+
+type consumption struct {
+	lotID  int64
+	amount float64
+}
+
+func roundPoints(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// validSum отсекает нулевые, отрицательные, NaN и бесконечные суммы списаний и переводов.
+func validSum(sum float64) bool {
+	return sum > 0 && !math.IsInf(sum, 0)
+}
+
+// validAccrual отсекает отрицательные, NaN и бесконечные начисления от системы расчёта и администратора.
+func validAccrual(accrual float64) bool {
+	return accrual >= 0 && !math.IsInf(accrual, 0)
+}
+
+// payDebt гасит долг пользователя из amount, остаток зачисляет на счёт и возвращает его.
+func payDebt(tx *sql.Tx, userID string, amount float64) (float64, error) {
+	var debt float64
+	queryGetDebt := `SELECT debt FROM users WHERE user_id = ($1) FOR UPDATE`
+	err := tx.QueryRow(queryGetDebt, userID).Scan(&debt)
+	if err != nil {
+		return 0, err
+	}
+
+	paid := math.Min(debt, amount)
+	rest := roundPoints(amount - paid)
+	queryCredit := `UPDATE users SET debt = debt - ($1), current = current + ($2) WHERE user_id = ($3)`
+	_, err = tx.Exec(queryCredit, paid, rest, userID)
+	if err != nil {
+		return 0, err
+	}
+	return rest, nil
+}
+
+// credit пополняет счёт пользователя, в первую очередь погашая его долг.
+// На зачисленный остаток заводится партия, сгорающая через Expiry.Months.
+func (r *RepoDB) credit(tx *sql.Tx, userID string, orderID string, amount float64) error {
+	rest, err := payDebt(tx, userID, amount)
+	if err != nil || rest <= 0 {
+		return err
+	}
+
+	now := time.Now()
+	return addLot(tx, userID, orderID, rest, now, r.lotExpiry(now))
+}
+
+func (r *RepoDB) lotExpiry(earnedAt time.Time) *time.Time {
+	if r.cfg.Expiry.Months <= 0 {
+		return nil
+	}
+	expiresAt := earnedAt.AddDate(0, r.cfg.Expiry.Months, 0)
+	return &expiresAt
+}
+
+func addLot(tx *sql.Tx, userID string, source string, amount float64, earnedAt time.Time, expiresAt *time.Time) error {
+	queryAddLot := `INSERT INTO point_lots (user_id, order_id, amount, remaining, earned_at, expires_at) VALUES ($1, $2, $3, $3, $4, $5)`
+	_, err := tx.Exec(queryAddLot, userID, source, amount, earnedAt, expiresAt)
+	return err
+}
+
+// debit списывает amount по политике ReversalPolicy и возвращает часть, ушедшую в долг.
+func (r *RepoDB) debit(tx *sql.Tx, userID string, orderID string, amount float64) (float64, error) {
+	if r.cfg.ReversalPolicy != ReversalDebt {
+		queryDebit := `UPDATE users SET current = current - ($1) WHERE user_id = ($2)`
+		_, err := tx.Exec(queryDebit, amount, userID)
+		if err != nil {
+			return 0, err
+		}
+		return 0, consume(tx, userID, ConsumedByAdjustment, orderID, amount)
+	}
+
+	var current float64
+	queryGetCurrent := `SELECT current FROM users WHERE user_id = ($1) FOR UPDATE`
+	err := tx.QueryRow(queryGetCurrent, userID).Scan(&current)
+	if err != nil {
+		return 0, err
+	}
+
+	charged := math.Max(math.Min(current, amount), 0)
+	debt := roundPoints(amount - charged)
+	queryDebit := `UPDATE users SET current = current - ($1), debt = debt + ($2) WHERE user_id = ($3)`
+	_, err = tx.Exec(queryDebit, charged, debt, userID)
+	if err != nil {
+		return 0, err
+	}
+	return debt, consume(tx, userID, ConsumedByAdjustment, orderID, charged)
+}
+
+// consume расходует партии пользователя по FIFO и запоминает, из каких партий списано, под kind и ref.
+// Если партий не хватает, остаток считается списанным с баланса без партий.
+func consume(tx *sql.Tx, userID string, kind string, ref string, amount float64) error {
+	if amount <= 0 {
+		return nil
+	}
+
+	queryGetLots := `SELECT id, order_id, remaining FROM point_lots WHERE user_id = ($1) AND remaining > 0
+		ORDER BY expires_at ASC NULLS LAST, id ASC FOR UPDATE`
+	lots, err := selectLots(tx, queryGetLots, userID)
+	if err != nil {
+		return err
+	}
+
+	available := make([]consumption, 0, len(lots))
+	for _, l := range lots {
+		available = append(available, consumption{lotID: l.id, amount: l.remaining})
+	}
+	taken, _ := splitConsumptions(available, amount)
+
+	for _, c := range taken {
+		queryTakeLot := `UPDATE point_lots SET remaining = remaining - ($1) WHERE id = ($2)`
+		_, err = tx.Exec(queryTakeLot, c.amount, c.lotID)
+		if err != nil {
+			return err
+		}
+		queryAddConsumption := `INSERT INTO lot_consumptions (lot_id, kind, ref, amount) VALUES ($1, $2, $3, $4)`
+		_, err = tx.Exec(queryAddConsumption, c.lotID, kind, ref, c.amount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore возвращает на счёт отменённое списание: гасит долг, а остаток возвращает в те партии,
+// из которых списание было сделано. Уже сгоревшие партии сгорят снова при следующем проходе expireLots.
+func restore(tx *sql.Tx, userID string, kind string, ref string, amount float64) error {
+	rest, err := payDebt(tx, userID, amount)
+	if err != nil {
+		return err
+	}
+
+	var consumptions []consumption
+	queryGetConsumptions := `SELECT lot_id, amount FROM lot_consumptions WHERE kind = ($1) AND ref = ($2) ORDER BY lot_id ASC`
+	rows, err := tx.Query(queryGetConsumptions, kind, ref)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var c consumption
+		if err := rows.Scan(&c.lotID, &c.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		consumptions = append(consumptions, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	returned, _ := splitConsumptions(consumptions, rest)
+	for _, c := range returned {
+		queryRestoreLot := `UPDATE point_lots SET remaining = remaining + ($1) WHERE id = ($2)`
+		_, err = tx.Exec(queryRestoreLot, c.amount, c.lotID)
+		if err != nil {
+			return err
+		}
+	}
+
+	queryDeleteConsumptions := `DELETE FROM lot_consumptions WHERE kind = ($1) AND ref = ($2)`
+	_, err = tx.Exec(queryDeleteConsumptions, kind, ref)
+	return err
+}
+
+// retag переносит amount из расхода партий под kind и ref на newKind и newRef, начиная с партий,
+// которые сгорают раньше. Остаток расхода остаётся под прежними kind и ref.
+func retag(tx *sql.Tx, kind string, ref string, newKind string, newRef string, amount float64) error {
+	var consumptions []consumption
+	queryGetConsumptions := `SELECT c.lot_id, c.amount FROM lot_consumptions c JOIN point_lots l ON l.id = c.lot_id
+		WHERE c.kind = ($1) AND c.ref = ($2) ORDER BY l.expires_at ASC NULLS LAST, l.id ASC`
+	rows, err := tx.Query(queryGetConsumptions, kind, ref)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var c consumption
+		if err := rows.Scan(&c.lotID, &c.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		consumptions = append(consumptions, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	taken, left := splitConsumptions(consumptions, amount)
+
+	queryDeleteConsumptions := `DELETE FROM lot_consumptions WHERE kind = ($1) AND ref = ($2)`
+	_, err = tx.Exec(queryDeleteConsumptions, kind, ref)
+	if err != nil {
+		return err
+	}
+	queryAddConsumption := `INSERT INTO lot_consumptions (lot_id, kind, ref, amount) VALUES ($1, $2, $3, $4)`
+	for _, c := range taken {
+		if _, err := tx.Exec(queryAddConsumption, c.lotID, newKind, newRef, c.amount); err != nil {
+			return err
+		}
+	}
+	for _, c := range left {
+		if _, err := tx.Exec(queryAddConsumption, c.lotID, kind, ref, c.amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitConsumptions делит расход партий на первые amount баллов и остаток; расход одной партии может разойтись на обе части.
+// По нему же consume списывает партии по порядку их сгорания, а restore возвращает баллы в партии.
+func splitConsumptions(consumptions []consumption, amount float64) (taken []consumption, left []consumption) {
+	for _, c := range consumptions {
+		take := math.Max(math.Min(c.amount, amount), 0)
+		amount = roundPoints(amount - take)
+		if take > 0 {
+			taken = append(taken, consumption{lotID: c.lotID, amount: take})
+		}
+		if rest := roundPoints(c.amount - take); rest > 0 {
+			left = append(left, consumption{lotID: c.lotID, amount: rest})
+		}
+	}
+	return taken, left
+}
+
+func selectLots(tx *sql.Tx, query string, args ...interface{}) ([]lot, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lots []lot
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.id, &l.orderID, &l.remaining); err != nil {
+			return nil, err
+		}
+		lots = append(lots, l)
+	}
+	return lots, rows.Err()
+}
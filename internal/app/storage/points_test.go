@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSplitConsumptions(t *testing.T) {
+	consumptions := []consumption{{lotID: 1, amount: 30}, {lotID: 2, amount: 50}, {lotID: 3, amount: 20}}
+
+	tests := []struct {
+		name      string
+		amount    float64
+		wantTaken []consumption
+		wantLeft  []consumption
+	}{
+		{"nothing", 0, nil, consumptions},
+		{"whole first lot", 30, []consumption{{1, 30}}, []consumption{{2, 50}, {3, 20}}},
+		{"splits a lot", 45.5, []consumption{{1, 30}, {2, 15.5}}, []consumption{{2, 34.5}, {3, 20}}},
+		{"everything", 100, consumptions, nil},
+		{"more than consumed", 150, consumptions, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taken, left := splitConsumptions(consumptions, tt.amount)
+			if !reflect.DeepEqual(taken, tt.wantTaken) {
+				t.Errorf("taken = %v, want %v", taken, tt.wantTaken)
+			}
+			if !reflect.DeepEqual(left, tt.wantLeft) {
+				t.Errorf("left = %v, want %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestSplitConsumptionsFIFO(t *testing.T) {
+	// партии в порядке сгорания, как их отдаёт consume
+	lots := []consumption{{lotID: 7, amount: 0.1}, {lotID: 3, amount: 0.2}, {lotID: 9, amount: 10}}
+
+	tests := []struct {
+		name      string
+		amount    float64
+		wantTaken []consumption
+	}{
+		{"earliest lot first", 0.05, []consumption{{7, 0.05}}},
+		{"no float drift", 0.3, []consumption{{7, 0.1}, {3, 0.2}}},
+		{"into the last lot", 5.3, []consumption{{7, 0.1}, {3, 0.2}, {9, 5}}},
+		{"nothing to take", 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taken, _ := splitConsumptions(lots, tt.amount)
+			if !reflect.DeepEqual(taken, tt.wantTaken) {
+				t.Errorf("taken = %v, want %v", taken, tt.wantTaken)
+			}
+		})
+	}
+}
+
+func TestValidSum(t *testing.T) {
+	tests := []struct {
+		sum  float64
+		want bool
+	}{
+		{0.01, true},
+		{100, true},
+		{0, false},
+		{-1, false},
+		{math.Inf(1), false},
+		{math.NaN(), false},
+	}
+
+	for _, tt := range tests {
+		if got := validSum(tt.sum); got != tt.want {
+			t.Errorf("validSum(%v) = %v, want %v", tt.sum, got, tt.want)
+		}
+	}
+}
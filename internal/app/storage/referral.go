@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+const (
+	ReferralPending  = "PENDING"
+	ReferralRewarded = "REWARDED"
+	ReferralRejected = "REJECTED"
+)
+
+const (
+	rejectedMonthlyLimit = "referrer monthly limit reached"
+	rejectedLowAccrual   = "first order accrual below minimum"
+)
+
+const (
+	referralCodeAttempts = 5
+	referralSweepBatch   = 100
+)
+
+// ReferralConfig - бонусы реферальной программы. Реферер получает вознаграждение не больше MonthlyLimit раз
+// за календарный месяц (UTC), а первый рассчитанный заказ приглашённого должен принести не меньше MinAccrual.
+// Приглашения, не обработанные после расчёта заказа, referralLoop проверяет снова раз в SweepInterval.
+type ReferralConfig struct {
+	ReferrerBonus float64
+	RefereeBonus  float64
+	MonthlyLimit  int
+	MinAccrual    float64
+	SweepInterval time.Duration
+}
+
+func newReferralCode() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(buf), nil
+}
+
+func referralRef(refereeID string) string {
+	return "referral-" + refereeID
+}
+
+func (r *RepoDB) CreateUser(login string, passwordHash string, referralCode string) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer rollback(tx)
+
+	var referrerID sql.NullInt64
+	if referralCode != "" {
+		queryGetReferrer := `SELECT user_id FROM users WHERE referral_code = ($1)`
+		err = tx.QueryRow(queryGetReferrer, strings.ToUpper(referralCode)).Scan(&referrerID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return "", ErrUnknownReferralCode
+			}
+			return "", err
+		}
+	}
+
+	// при совпадении сгенерированного кода с чужим вставка пропускается и повторяется с новым кодом,
+	// а занятый логин по-прежнему возвращает ошибку ограничения
+	var userID int64
+	querySaveUser := `INSERT INTO users (login, password_hash, referral_code) VALUES ($1, $2, $3)
+		ON CONFLICT (referral_code) DO NOTHING RETURNING user_id`
+	for attempt := 1; ; attempt++ {
+		code, err := newReferralCode()
+		if err != nil {
+			return "", err
+		}
+		err = tx.QueryRow(querySaveUser, login, passwordHash, code).Scan(&userID)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, sql.ErrNoRows) || attempt == referralCodeAttempts {
+			return "", err
+		}
+	}
+
+	if referrerID.Valid {
+		queryAddReferral := `INSERT INTO referrals (referee_id, referrer_id, status, created_at) VALUES ($1, $2, $3, $4)`
+		_, err = tx.Exec(queryAddReferral, userID, referrerID.Int64, ReferralPending, time.Now())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(userID, 10), nil
+}
+
+func (r *RepoDB) referralLoop() {
+	interval := r.cfg.Referrals.SweepInterval
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := r.sweepReferrals(); err != nil {
+			logger.Logger.Err(err).Msg("")
+		}
+	}
+}
+
+// sweepReferrals повторяет rewardReferral для ожидающих приглашений, у которых уже есть рассчитанный заказ:
+// вызов после finalizeOrder мог не пройти из-за ошибки базы или остановки сервиса.
+func (r *RepoDB) sweepReferrals() error {
+	var refereeIDs []string
+	queryGetPending := `SELECT rf.referee_id::text FROM referrals rf
+		WHERE rf.status = ($1) AND EXISTS (SELECT 1 FROM orders o WHERE o.user_id = rf.referee_id AND o.status = ($2))
+		ORDER BY rf.created_at ASC LIMIT ($3)`
+	err := r.db.Select(&refereeIDs, queryGetPending, ReferralPending, PROCESSED, referralSweepBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, refereeID := range refereeIDs {
+		if err := r.rewardReferral(refereeID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewardReferral начисляет бонусы рефереру и приглашённому после первого рассчитанного заказа приглашённого.
+// Вызывается после фиксации finalizeOrder отдельной транзакцией, чтобы блокировать обоих пользователей
+// в порядке user_id, и из referralLoop; повторные вызовы ничего не делают.
+func (r *RepoDB) rewardReferral(refereeID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	var referrerID string
+	queryGetReferral := `SELECT referrer_id::text FROM referrals WHERE referee_id = ($1) AND status = ($2) FOR UPDATE`
+	err = tx.QueryRow(queryGetReferral, refereeID, ReferralPending).Scan(&referrerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	var orderID string
+	var accrual float64
+	queryGetFirstOrder := `SELECT order_id, COALESCE(base_accrual, accrual) FROM orders WHERE user_id = ($1) AND status = ($2)
+		ORDER BY processed_at ASC, order_id ASC LIMIT 1`
+	err = tx.QueryRow(queryGetFirstOrder, refereeID, PROCESSED).Scan(&orderID, &accrual)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	err = lockUsers(tx, referrerID, refereeID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	cfg := r.cfg.Referrals
+	reason := ""
+	if accrual < cfg.MinAccrual {
+		reason = rejectedLowAccrual
+	} else if cfg.MonthlyLimit > 0 {
+		var rewarded int
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		queryCountRewarded := `SELECT COUNT(*) FROM referrals WHERE referrer_id = ($1) AND status = ($2) AND resolved_at >= ($3)`
+		err = tx.QueryRow(queryCountRewarded, referrerID, ReferralRewarded, monthStart).Scan(&rewarded)
+		if err != nil {
+			return err
+		}
+		if rewarded >= cfg.MonthlyLimit {
+			reason = rejectedMonthlyLimit
+		}
+	}
+
+	if reason != "" {
+		queryRejectReferral := `UPDATE referrals SET status = ($1), reason = ($2), order_id = ($3), resolved_at = ($4) WHERE referee_id = ($5)`
+		_, err = tx.Exec(queryRejectReferral, ReferralRejected, reason, orderID, now, refereeID)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	ref := referralRef(refereeID)
+	if cfg.ReferrerBonus > 0 {
+		if err := r.credit(tx, referrerID, ref, cfg.ReferrerBonus); err != nil {
+			return err
+		}
+	}
+	if cfg.RefereeBonus > 0 {
+		if err := r.credit(tx, refereeID, ref, cfg.RefereeBonus); err != nil {
+			return err
+		}
+	}
+
+	queryRewardReferral := `UPDATE referrals SET status = ($1), order_id = ($2), referrer_bonus = ($3), referee_bonus = ($4), resolved_at = ($5)
+		WHERE referee_id = ($6)`
+	_, err = tx.Exec(queryRewardReferral, ReferralRewarded, orderID, cfg.ReferrerBonus, cfg.RefereeBonus, now, refereeID)
+	if err != nil {
+		return err
+	}
+
+	for _, uid := range []string{referrerID, refereeID} {
+		if err := r.notifyBalance(tx, uid); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetReferrals возвращает код пользователя и приглашённых им. Пользователям, зарегистрированным
+// до появления программы, код выдаётся при первом обращении.
+func (r *RepoDB) GetReferrals(userID string) (entity.Referrals, error) {
+	var referrals entity.Referrals
+	queryGetCode := `SELECT COALESCE(referral_code, '') FROM users WHERE user_id = ($1)`
+	err := r.db.Get(&referrals.Code, queryGetCode, userID)
+	if err != nil {
+		return referrals, err
+	}
+
+	querySetCode := `UPDATE users SET referral_code = COALESCE(referral_code, ($1)) WHERE user_id = ($2) RETURNING referral_code`
+	for attempt := 1; referrals.Code == ""; attempt++ {
+		code, err := newReferralCode()
+		if err != nil {
+			return referrals, err
+		}
+		err = r.db.Get(&referrals.Code, querySetCode, code, userID)
+		if err != nil {
+			var pgErr *pgconn.PgError
+			if !errors.As(err, &pgErr) || pgErr.Code != pgerrcode.UniqueViolation || attempt == referralCodeAttempts {
+				return referrals, err
+			}
+		}
+	}
+
+	queryGetReferrals := `SELECT u.login, rf.status, COALESCE(rf.reason, '') AS reason, COALESCE(rf.referrer_bonus, 0) AS bonus,
+			rf.created_at, rf.resolved_at
+		FROM referrals rf JOIN users u ON u.user_id = rf.referee_id
+		WHERE rf.referrer_id = ($1) ORDER BY rf.created_at DESC`
+	referrals.Referrals = []entity.Referral{}
+	err = r.db.Select(&referrals.Referrals, queryGetReferrals, userID)
+	if err != nil {
+		return referrals, err
+	}
+
+	return referrals, nil
+}
@@ -8,12 +8,16 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/jmoiron/sqlx"
 
 	"github.com/devkekops/gophermart/internal/app/client"
 	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/events"
 	"github.com/devkekops/gophermart/internal/app/logger"
+	"github.com/devkekops/gophermart/internal/app/loyalty"
 )
 
 const (
@@ -24,6 +28,18 @@ const (
 	PROCESSED  = "PROCESSED"
 )
 
+const (
+	RefundedByUser  = "user"
+	RefundedByAdmin = "admin"
+)
+
+const (
+	OrderAccepted       = "accepted"
+	OrderDuplicateOwn   = "duplicate-own"
+	OrderDuplicateOther = "duplicate-other"
+	OrderInvalid        = "invalid"
+)
+
 var schema = `
 CREATE TABLE IF NOT EXISTS users(
 	user_id			SERIAL PRIMARY KEY,
@@ -46,138 +62,343 @@ CREATE TABLE IF NOT EXISTS withdrawals(
 	user_id			INTEGER NOT NULL,
 	sum				NUMERIC(15,2) NOT NULL,
 	processed_at	TIMESTAMP WITH TIME ZONE NOT NULL
-);`
+);
 
-type Task struct {
-	userID  string
-	orderID string
-}
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS last_error TEXT NOT NULL DEFAULT '';
 
-type Worker struct {
-	id    int
-	repo  *RepoDB
-	timer *time.Timer
-}
+CREATE TABLE IF NOT EXISTS dead_letters(
+	order_id		TEXT NOT NULL UNIQUE,
+	user_id			INTEGER NOT NULL,
+	attempts		INTEGER NOT NULL,
+	last_error		TEXT NOT NULL,
+	failed_at		TIMESTAMP WITH TIME ZONE NOT NULL
+);
 
-func (w *Worker) loop() {
-	// worker в цикле проходит по очереди на отправку и шлёт запросы в систему через инициализированный клиент:
-	// 		- при статус коде 200 - обновляет status, если status PROCESSED или INVALID - обновляем accrual для заказа, удаляем из очереди,
-	//			если REGISTERED - переместить в конец очереди, если PROCESSING - обновить статус и переместить в конец очереди
-	//		- при статус коде 429 - можешь прийти не раньше чем через 5(или какое-то другое число) секунд (сделать на channel-ах retry)
-	queryUpdateOrderStatus := `UPDATE orders SET status = ($1) WHERE order_id = ($2)`
-	queryUpdateOrderStatusAccrual := `UPDATE orders SET status = ($1), accrual = ($2) WHERE order_id = ($3)`
-	queryUpdateUserCurrent := `UPDATE users SET current = current + ($1) WHERE user_id = ($2)`
-
-	for {
-		<-w.timer.C
-	taskloop:
-		for {
-			task := <-w.repo.taskCh
-			_, err := w.repo.db.Exec(queryUpdateOrderStatus, NEW, task.orderID)
-			if err != nil {
-				logger.Logger.Error().Msgf("worker #%d task #%v error: %v\n", w.id, task, err)
-			}
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS lease_owner TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP WITH TIME ZONE;
 
-			accrualResp, err := w.repo.client.GetAccrualInfo(task.orderID)
-			if err != nil {
-				logger.Logger.Error().Msgf("worker #%d task #%v error: %v\n", w.id, task, err)
-			}
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS polls INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS poll_started_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS review_flagged_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS orders_due_idx ON orders (next_poll_at) WHERE status IN ('NEW', 'REGISTERED', 'PROCESSING') AND review_flagged_at IS NULL;
 
-			switch accrualResp.StatusCode {
-			case 200:
-				switch accrualResp.Status {
-				case REGISTERED:
-					w.repo.taskCh <- task
-
-				case PROCESSING:
-					_, err := w.repo.db.Exec(queryUpdateOrderStatus, PROCESSING, task.orderID)
-					if err != nil {
-						logger.Logger.Error().Msgf("worker #%d task #%v error: %v\n", w.id, task, err)
-					}
-					w.repo.taskCh <- task
-
-				case INVALID:
-					_, err := w.repo.db.Exec(queryUpdateOrderStatus, INVALID, task.orderID)
-					if err != nil {
-						logger.Logger.Error().Msgf("worker #%d task #%v error: %v\n", w.id, task, err)
-					}
-
-				case PROCESSED:
-					tx, err := w.repo.db.Begin()
-					if err != nil {
-						logger.Logger.Error().Msgf("worker #%d task #%v error: %v\n", w.id, task, err)
-					}
-					defer func(tx *sql.Tx) {
-						err := tx.Rollback()
-						if err != nil {
-							logger.Logger.Error().Msgf("worker #%d task #%v error: %v\n", w.id, task, err)
-						}
-					}(tx)
-
-					_, err = tx.Exec(queryUpdateOrderStatusAccrual, PROCESSED, accrualResp.Accrual, task.orderID)
-					if err != nil {
-						logger.Logger.Error().Msgf("worker #%d task #%v error: %v\n", w.id, task, err)
-					}
-					_, err = tx.Exec(queryUpdateUserCurrent, accrualResp.Accrual, task.userID)
-					if err != nil {
-						logger.Logger.Error().Msgf("worker #%d task #%v error: %v\n", w.id, task, err)
-					}
-					err = tx.Commit()
-					if err != nil {
-						logger.Logger.Error().Msgf("worker #%d task #%v error: %v\n", w.id, task, err)
-					}
-				}
-			case 429:
-				w.timer.Reset(10 * time.Second)
-				break taskloop
-			case 500:
-				w.repo.taskCh <- task
-			}
-		}
-	}
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS provider TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS merchant TEXT;
+
+CREATE INDEX IF NOT EXISTS orders_user_uploaded_idx ON orders (user_id, uploaded_at, order_id);
+CREATE INDEX IF NOT EXISTS withdrawals_user_processed_idx ON withdrawals (user_id, processed_at, order_id);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS processed_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+	user_id			INTEGER NOT NULL,
+	key				TEXT NOT NULL,
+	request_hash	VARCHAR(64) NOT NULL,
+	status_code		INTEGER NOT NULL DEFAULT 0,
+	content_type	TEXT NOT NULL DEFAULT '',
+	body			BYTEA,
+	created_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	PRIMARY KEY (user_id, key)
+);
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS locked_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_idx ON idempotency_keys (created_at);
+
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS refunded_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS refund_reason TEXT;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS refunded_by TEXT;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS debt NUMERIC(15,2) NOT NULL DEFAULT 0.00;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS original_accrual NUMERIC(15,2);
+
+CREATE TABLE IF NOT EXISTS accrual_adjustments(
+	id				BIGSERIAL PRIMARY KEY,
+	order_id		TEXT NOT NULL,
+	user_id			INTEGER NOT NULL,
+	accrual			NUMERIC(15,2) NOT NULL,
+	delta			NUMERIC(15,2) NOT NULL,
+	debt			NUMERIC(15,2) NOT NULL DEFAULT 0.00,
+	reason			TEXT NOT NULL,
+	adjusted_by		TEXT NOT NULL,
+	created_at		TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS accrual_adjustments_order_idx ON accrual_adjustments (order_id);
+
+CREATE TABLE IF NOT EXISTS point_lots(
+	id				BIGSERIAL PRIMARY KEY,
+	user_id			INTEGER NOT NULL,
+	order_id		TEXT NOT NULL,
+	amount			NUMERIC(15,2) NOT NULL,
+	remaining		NUMERIC(15,2) NOT NULL,
+	earned_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	expires_at		TIMESTAMP WITH TIME ZONE,
+	expired_at		TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS point_lots_user_idx ON point_lots (user_id, expires_at) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS point_lots_due_idx ON point_lots (expires_at) WHERE remaining > 0;
+
+CREATE TABLE IF NOT EXISTS lot_consumptions(
+	lot_id			BIGINT NOT NULL,
+	kind			TEXT NOT NULL,
+	ref				TEXT NOT NULL,
+	amount			NUMERIC(15,2) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS lot_consumptions_ref_idx ON lot_consumptions (kind, ref);
+
+CREATE TABLE IF NOT EXISTS point_expirations(
+	id				BIGSERIAL PRIMARY KEY,
+	user_id			INTEGER NOT NULL,
+	order_id		TEXT NOT NULL,
+	amount			NUMERIC(15,2) NOT NULL,
+	expired_at		TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS require_transfer_confirmation BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS transfers(
+	id				BIGSERIAL PRIMARY KEY,
+	sender_id		INTEGER NOT NULL,
+	recipient_id	INTEGER NOT NULL,
+	sum				NUMERIC(15,2) NOT NULL,
+	comment			TEXT NOT NULL DEFAULT '',
+	status			VARCHAR(10) NOT NULL,
+	created_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	resolved_at		TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS transfers_sender_idx ON transfers (sender_id, created_at);
+CREATE INDEX IF NOT EXISTS transfers_recipient_idx ON transfers (recipient_id, created_at);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tier TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS base_accrual NUMERIC(15,2);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS multiplier NUMERIC(6,3);
+CREATE INDEX IF NOT EXISTS orders_user_processed_idx ON orders (user_id, processed_at) WHERE status = 'PROCESSED';
+
+CREATE TABLE IF NOT EXISTS bonus_rules(
+	id				BIGSERIAL PRIMARY KEY,
+	priority		INTEGER NOT NULL,
+	enabled			BOOLEAN NOT NULL,
+	definition		JSONB NOT NULL,
+	created_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	updated_at		TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS rule_firings(
+	order_id		TEXT NOT NULL,
+	user_id			INTEGER NOT NULL,
+	rule_id			BIGINT NOT NULL,
+	rule_name		TEXT NOT NULL,
+	bonus			NUMERIC(15,2) NOT NULL,
+	seq				INTEGER NOT NULL,
+	fired_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	PRIMARY KEY (order_id, rule_id)
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS referral_code TEXT UNIQUE;
+
+CREATE TABLE IF NOT EXISTS referrals(
+	referee_id		INTEGER PRIMARY KEY,
+	referrer_id		INTEGER NOT NULL,
+	status			VARCHAR(10) NOT NULL,
+	reason			TEXT,
+	order_id		TEXT,
+	referrer_bonus	NUMERIC(15,2),
+	referee_bonus	NUMERIC(15,2),
+	created_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	resolved_at		TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS referrals_referrer_idx ON referrals (referrer_id, created_at);
+
+CREATE TABLE IF NOT EXISTS voucher_batches(
+	id				BIGSERIAL PRIMARY KEY,
+	value			NUMERIC(15,2) NOT NULL,
+	usage_limit		INTEGER NOT NULL,
+	expires_at		TIMESTAMP WITH TIME ZONE,
+	count			INTEGER NOT NULL,
+	created_at		TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS vouchers(
+	code_hash		VARCHAR(64) PRIMARY KEY,
+	batch_id		BIGINT NOT NULL,
+	uses			INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS vouchers_batch_idx ON vouchers (batch_id);
+
+CREATE TABLE IF NOT EXISTS voucher_redemptions(
+	id				BIGSERIAL PRIMARY KEY,
+	code_hash		VARCHAR(64) NOT NULL,
+	user_id			INTEGER NOT NULL,
+	batch_id		BIGINT NOT NULL,
+	value			NUMERIC(15,2) NOT NULL,
+	redeemed_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	UNIQUE (code_hash, user_id)
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ALTER COLUMN created_at SET DEFAULT now();
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'PROCESSED';
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS review_reason TEXT;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS reviewed_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS withdrawals_review_idx ON withdrawals (processed_at) WHERE status = 'REVIEW';
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS held NUMERIC(15,2) NOT NULL DEFAULT 0.00;
+
+CREATE TABLE IF NOT EXISTS holds(
+	id				BIGSERIAL PRIMARY KEY,
+	order_id		TEXT NOT NULL,
+	user_id			INTEGER NOT NULL,
+	sum				NUMERIC(15,2) NOT NULL,
+	captured		NUMERIC(15,2),
+	status			TEXT NOT NULL,
+	review_reason	TEXT,
+	created_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	expires_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	resolved_at		TIMESTAMP WITH TIME ZONE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS holds_active_order_idx ON holds (order_id) WHERE status = 'HELD';
+CREATE INDEX IF NOT EXISTS holds_user_idx ON holds (user_id, created_at);
+CREATE INDEX IF NOT EXISTS holds_expires_idx ON holds (expires_at) WHERE status = 'HELD';
+
+CREATE TABLE IF NOT EXISTS api_keys(
+	id				BIGSERIAL PRIMARY KEY,
+	user_id			INTEGER NOT NULL,
+	key_hash		VARCHAR(64) NOT NULL UNIQUE,
+	name			TEXT NOT NULL,
+	created_at		TIMESTAMP WITH TIME ZONE NOT NULL,
+	last_used_at	TIMESTAMP WITH TIME ZONE,
+	revoked_at		TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_idx ON api_keys (user_id);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS rechecked_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS orders_recheck_idx ON orders (processed_at, rechecked_at) WHERE status = 'PROCESSED';`
+
+// Config - настройки хранилища: опрос системы расчёта и правила движения баллов.
+type Config struct {
+	WorkerConfig
+	ReversalPolicy string
+	Recheck        RecheckConfig
+	Expiry         ExpiryConfig
+	Transfers      TransferLimits
+	// Tiers - уровни программы лояльности, nil - loyalty.DefaultConfig.
+	Tiers              *loyalty.Tiers
+	TierRecalcInterval time.Duration
+	Referrals          ReferralConfig
+	Withdrawals        WithdrawalLimits
+	Holds              HoldConfig
+	Idempotency        IdempotencyConfig
 }
 
 type RepoDB struct {
-	db     *sqlx.DB
-	client client.Client
-	taskCh chan *Task
+	db        *sqlx.DB
+	providers *client.Registry
+	taskCh    chan []*Task
+	wakeCh    chan struct{}
+	events    *events.Bus
+	cfg       Config
 }
 
-func NewRepoDB(databaseURI string, client client.Client) (*RepoDB, error) {
+func NewRepoDB(databaseURI string, providers *client.Registry, cfg Config) (*RepoDB, error) {
+	if !validReversalPolicy(cfg.ReversalPolicy) {
+		return nil, fmt.Errorf("unknown accrual reversal policy %q", cfg.ReversalPolicy)
+	}
+	if cfg.Tiers == nil {
+		tiers, err := loyalty.NewTiers(loyalty.DefaultConfig())
+		if err != nil {
+			return nil, err
+		}
+		cfg.Tiers = tiers
+	}
+
 	db, err := sqlx.Connect("pgx", databaseURI)
 	if err != nil {
 		return nil, err
 	}
 
-	db.MustExec(schema)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := ensureWithdrawalsUnique(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(historyView); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	r := &RepoDB{
-		db:     db,
-		client: client,
-		taskCh: make(chan *Task),
+		db:        db,
+		providers: providers,
+		taskCh:    make(chan []*Task),
+		wakeCh:    make(chan struct{}, 1),
+		events:    events.NewBus(),
+		cfg:       cfg,
 	}
 
 	workers := make([]*Worker, 0, runtime.NumCPU())
 	for i := 0; i < runtime.NumCPU(); i++ {
-		workers = append(workers, &Worker{i, r, time.NewTimer(0)})
+		workers = append(workers, &Worker{i, r})
 	}
 
 	for _, w := range workers {
 		go w.loop()
 	}
 
+	go r.dispatch(len(workers))
+	go r.expireLoop()
+	go r.tierLoop()
+	go r.holdLoop()
+	go r.idempotencyLoop()
+	go r.recheckLoop()
+	go r.transferExpiryLoop()
+	go r.referralLoop()
+	go r.listen(databaseURI)
+
 	return r, nil
 }
 
-func (r *RepoDB) CreateUser(login string, passwordHash string) (string, error) {
-	var userID int64
-	querySaveUser := `INSERT INTO users (login, password_hash) VALUES ($1, $2) RETURNING user_id;`
-	err := r.db.Get(&userID, querySaveUser, login, passwordHash)
+// ensureWithdrawalsUnique строит уникальный индекс по номеру заказа среди невозвращённых списаний:
+// после возврата или отклонения заказ можно снова оплатить баллами. Прежний индекс по всем строкам удаляется.
+// Если в старой базе уже есть дубли, индекс не построится; каждый дубль уже списал баллы, поэтому вместо
+// паники или автоматического удаления возвращаем ошибку с номером заказа, и дубли разбираются вручную.
+func ensureWithdrawalsUnique(db *sqlx.DB) error {
+	var exists bool
+	queryIndexExists := `SELECT EXISTS (SELECT 1 FROM pg_indexes WHERE tablename = 'withdrawals' AND indexname = 'withdrawals_order_id_active_key')`
+	if err := db.Get(&exists, queryIndexExists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	var orderID string
+	queryFindDuplicate := `SELECT order_id FROM withdrawals WHERE refunded_at IS NULL GROUP BY order_id HAVING COUNT(*) > 1 LIMIT 1`
+	err := db.Get(&orderID, queryFindDuplicate)
+	if err == nil {
+		return fmt.Errorf("withdrawals contain duplicates for order %s: resolve them before upgrade", orderID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	tx, err := db.Begin()
 	if err != nil {
-		return "", err
+		return err
 	}
+	defer rollback(tx)
 
-	return strconv.FormatInt(userID, 10), nil
+	_, err = tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS withdrawals_order_id_active_key ON withdrawals (order_id) WHERE refunded_at IS NULL`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DROP INDEX IF EXISTS withdrawals_order_id_key`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (r *RepoDB) AuthUser(login string, passwordHash string) (string, error) {
@@ -191,9 +412,10 @@ func (r *RepoDB) AuthUser(login string, passwordHash string) (string, error) {
 	return strconv.FormatInt(userID, 10), nil
 }
 
-func (r *RepoDB) LoadOrder(orderID string, userID string) error {
-	// 1. записывает в бд в таблицу order (order_id=orderID, user_id=userID, status=NEW, accrual=0, uploaded_at=time.Now())
-	// 2. добавляет новую задачу с {userID, orderID} в очередь на отправку в систему рассчёта
+func (r *RepoDB) LoadOrder(orderID string, userID string, merchant string) error {
+	// 1. записывает в бд в таблицу order (order_id=orderID, user_id=userID, status=NEW, accrual=0, uploaded_at=time.Now()) и провайдера по правилам маршрутизации
+	// 2. будит dispatch, который заберёт заказ в аренду и отдаст свободному worker-у; если будить не удалось,
+	//    заказ будет забран по расписанию через PollInterval
 	var userIDExisting int64
 	queryCheckIfOrderExists := `SELECT user_id FROM orders WHERE order_id = ($1)`
 	err := r.db.Get(&userIDExisting, queryCheckIfOrderExists, orderID)
@@ -210,64 +432,406 @@ func (r *RepoDB) LoadOrder(orderID string, userID string) error {
 		return fmt.Errorf("%w", ErrOrderExistsForOtherUser)
 	}
 
-	querySaveNewOrder := `INSERT INTO orders (order_id, user_id, status, uploaded_at) VALUES ($1, $2, $3, $4)`
-	_, err = r.db.Exec(querySaveNewOrder, orderID, userID, "NEW", time.Now().Truncate(time.Second))
+	provider := r.providers.Route(orderID, merchant)
+
+	querySaveNewOrder := `INSERT INTO orders (order_id, user_id, status, uploaded_at, poll_started_at, provider, merchant)
+		VALUES ($1, $2, $3, $4, $4, $5, NULLIF($6, ''))`
+	_, err = r.db.Exec(querySaveNewOrder, orderID, userID, NEW, time.Now().Truncate(time.Second), provider, merchant)
 	if err != nil {
 		return err
 	}
 
-	go func() {
-		r.taskCh <- &Task{userID, orderID}
-	}()
+	r.wake()
 
 	return nil
 }
 
-func (r *RepoDB) GetOrders(userID string) ([]entity.Order, error) {
-	var orders []entity.Order
-	queryGetOrders := "SELECT order_id, status, accrual, uploaded_at FROM orders WHERE user_id = ($1) ORDER BY uploaded_at ASC"
-	err := r.db.Select(&orders, queryGetOrders, userID)
+// LoadOrders сохраняет пачку заказов в одной транзакции и возвращает результат по каждому номеру.
+// Номера должны быть уже проверены. Новые заказы забирает dispatch.
+func (r *RepoDB) LoadOrders(orderIDs []string, userID string, merchant string) ([]entity.OrderUploadResult, error) {
+	tx, err := r.db.Beginx()
 	if err != nil {
 		return nil, err
 	}
+	defer rollback(tx.Tx)
 
-	return orders, nil
+	owners, err := orderOwners(tx, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var newOrders, providers []string
+	seen := make(map[string]bool, len(orderIDs))
+	for _, orderID := range orderIDs {
+		if _, ok := owners[orderID]; ok || seen[orderID] {
+			continue
+		}
+		seen[orderID] = true
+		newOrders = append(newOrders, orderID)
+		providers = append(providers, r.providers.Route(orderID, merchant))
+	}
+
+	inserted := make(map[string]bool, len(newOrders))
+	if len(newOrders) > 0 {
+		var insertedIDs []string
+		now := time.Now().Truncate(time.Second)
+		querySaveNewOrders := `INSERT INTO orders (order_id, user_id, status, uploaded_at, poll_started_at, provider, merchant)
+			SELECT o.order_id, $3, $4, $5, $5, o.provider, NULLIF($6, '') FROM unnest($1::text[], $2::text[]) AS o(order_id, provider)
+			ON CONFLICT (order_id) DO NOTHING
+			RETURNING order_id`
+		err = tx.Select(&insertedIDs, querySaveNewOrders, newOrders, providers, userID, NEW, now, merchant)
+		if err != nil {
+			return nil, err
+		}
+		for _, orderID := range insertedIDs {
+			inserted[orderID] = true
+		}
+
+		// заказы, которые успели загрузить параллельно, отчитываются как дубликаты
+		var raced []string
+		for _, orderID := range newOrders {
+			if !inserted[orderID] {
+				raced = append(raced, orderID)
+			}
+		}
+		if len(raced) > 0 {
+			racedOwners, err := orderOwners(tx, raced)
+			if err != nil {
+				return nil, err
+			}
+			for orderID, owner := range racedOwners {
+				owners[orderID] = owner
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]entity.OrderUploadResult, 0, len(orderIDs))
+	reported := make(map[string]bool, len(orderIDs))
+	for _, orderID := range orderIDs {
+		result := OrderAccepted
+		if owner, ok := owners[orderID]; ok {
+			result = OrderDuplicateOther
+			if owner == userID {
+				result = OrderDuplicateOwn
+			}
+		} else if reported[orderID] {
+			result = OrderDuplicateOwn
+		}
+		reported[orderID] = true
+		results = append(results, entity.OrderUploadResult{OrderID: orderID, Result: result})
+	}
+
+	r.wake()
+
+	return results, nil
+}
+
+func orderOwners(tx *sqlx.Tx, orderIDs []string) (map[string]string, error) {
+	var rows []struct {
+		OrderID string `db:"order_id"`
+		UserID  string `db:"user_id"`
+	}
+	queryGetOwners := `SELECT order_id, user_id::text AS user_id FROM orders WHERE order_id = ANY($1)`
+	err := tx.Select(&rows, queryGetOwners, orderIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string]string, len(rows))
+	for _, row := range rows {
+		owners[row.OrderID] = row.UserID
+	}
+	return owners, nil
+}
+
+func (r *RepoDB) GetOrders(userID string, q OrdersQuery) ([]entity.Order, string, error) {
+	var orders []entity.Order
+	queryGetOrders := "SELECT order_id, status, accrual, uploaded_at FROM orders WHERE user_id = ($1)"
+	args := []interface{}{userID}
+
+	if len(q.Statuses) > 0 {
+		args = append(args, q.Statuses)
+		queryGetOrders += fmt.Sprintf(" AND status = ANY($%d)", len(args))
+	}
+	if !q.From.IsZero() {
+		args = append(args, q.From)
+		queryGetOrders += fmt.Sprintf(" AND uploaded_at >= ($%d)", len(args))
+	}
+	if !q.To.IsZero() {
+		args = append(args, q.To)
+		queryGetOrders += fmt.Sprintf(" AND uploaded_at < ($%d)", len(args))
+	}
+
+	direction, cmp := "ASC", ">"
+	if q.Desc {
+		direction, cmp = "DESC", "<"
+	}
+	if q.Cursor != "" {
+		at, orderID, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, at, orderID)
+		queryGetOrders += fmt.Sprintf(" AND (uploaded_at, order_id) %s ($%d, $%d)", cmp, len(args)-1, len(args))
+	}
+
+	queryGetOrders += fmt.Sprintf(" ORDER BY uploaded_at %s, order_id %s", direction, direction)
+	if q.Limit > 0 {
+		args = append(args, q.Limit+1)
+		queryGetOrders += fmt.Sprintf(" LIMIT ($%d)", len(args))
+	}
+
+	err := r.db.Select(&orders, queryGetOrders, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if q.Limit > 0 && len(orders) > q.Limit {
+		orders = orders[:q.Limit]
+		last := orders[len(orders)-1]
+		next = encodeCursor(last.UploadedAt, last.OrderID)
+	}
+
+	err = r.orderAdjustments(orders)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return orders, next, nil
 }
 
 func (r *RepoDB) GetBalance(userID string) (entity.Balance, error) {
 	var balance entity.Balance
-	queryGetBalance := `SELECT current, withdrawn FROM users WHERE user_id = ($1)`
+	queryGetBalance := `SELECT current, current AS available, held, withdrawn, debt FROM users WHERE user_id = ($1)`
 	err := r.db.Get(&balance, queryGetBalance, userID)
 	if err != nil {
 		return balance, err
 	}
+
+	balance.ExpiringSoon, err = r.expiringSoon(r.db, userID)
+	if err != nil {
+		return balance, err
+	}
 	return balance, nil
 }
 
-func (r *RepoDB) Withdraw(orderID string, userID string, sum float64) error {
+// Withdraw списывает sum в счёт заказа orderID и возвращает статус списания:
+// WithdrawalReview, если списание ждёт решения администратора.
+func (r *RepoDB) Withdraw(orderID string, userID string, sum float64) (string, error) {
+	if !validSum(sum) {
+		return "", ErrInvalidSum
+	}
+
 	tx, err := r.db.Begin()
 	if err != nil {
+		return "", err
+	}
+	defer rollback(tx)
+
+	var newBalance float64
+	var registeredAt sql.NullTime
+	queryUpdateUserBalance := `UPDATE users SET current = current - ($1), withdrawn = withdrawn + ($1) WHERE user_id = ($2) RETURNING current, created_at`
+	err = tx.QueryRow(queryUpdateUserBalance, sum, userID).Scan(&newBalance, &registeredAt)
+	if err != nil {
+		return "", err
+	}
+	if newBalance < 0 {
+		return "", ErrInsufficientFunds
+	}
+
+	now := time.Now().Truncate(time.Second)
+	reviewReason, err := r.checkWithdrawal(tx, userID, sum, registeredAt, now)
+	if err != nil {
+		return "", err
+	}
+	status := WithdrawalProcessed
+	if reviewReason != "" {
+		status = WithdrawalReview
+	}
+
+	err = consume(tx, userID, ConsumedByWithdrawal, orderID, sum)
+	if err != nil {
+		return "", err
+	}
+
+	err = addWithdrawal(tx, orderID, userID, sum, status, reviewReason, now)
+	if err != nil {
+		return "", err
+	}
+
+	err = r.notifyBalance(tx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+
+	return status, nil
+}
+
+func addWithdrawal(tx *sql.Tx, orderID string, userID string, sum float64, status string, reviewReason string, now time.Time) error {
+	queryAddWithdraw := `INSERT INTO withdrawals (order_id, user_id, sum, processed_at, status, review_reason) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))`
+	_, err := tx.Exec(queryAddWithdraw, orderID, userID, sum, now, status, reviewReason)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return ErrWithdrawalExists
+		}
 		return err
 	}
-	defer func(tx *sql.Tx) {
-		err := tx.Rollback()
+	return nil
+}
+
+func (r *RepoDB) GetWithdrawals(userID string, q WithdrawalsQuery) (WithdrawalsPage, error) {
+	var page WithdrawalsPage
+	filter := " WHERE user_id = ($1)"
+	args := []interface{}{userID}
+
+	if !q.From.IsZero() {
+		args = append(args, q.From)
+		filter += fmt.Sprintf(" AND processed_at >= ($%d)", len(args))
+	}
+	if !q.To.IsZero() {
+		args = append(args, q.To)
+		filter += fmt.Sprintf(" AND processed_at < ($%d)", len(args))
+	}
+	if q.MinSum > 0 {
+		args = append(args, q.MinSum)
+		filter += fmt.Sprintf(" AND sum >= ($%d)", len(args))
+	}
+	if q.MaxSum > 0 {
+		args = append(args, q.MaxSum)
+		filter += fmt.Sprintf(" AND sum <= ($%d)", len(args))
+	}
+
+	// возвращённые и отклонённые списания показываются в списке, но в итоги не входят
+	queryGetTotals := "SELECT COUNT(*) AS count, COALESCE(SUM(sum), 0) AS sum FROM withdrawals" + filter + " AND refunded_at IS NULL"
+	err := r.db.Get(&page.Totals, queryGetTotals, args...)
+	if err != nil {
+		return page, err
+	}
+
+	direction, cmp := "ASC", ">"
+	if q.Desc {
+		direction, cmp = "DESC", "<"
+	}
+	if q.Cursor != "" {
+		at, orderID, err := decodeCursor(q.Cursor)
 		if err != nil {
-			logger.Logger.Err(err).Msg("")
+			return page, err
 		}
-	}(tx)
+		args = append(args, at, orderID)
+		filter += fmt.Sprintf(" AND (processed_at, order_id) %s ($%d, $%d)", cmp, len(args)-1, len(args))
+	}
 
-	var newBalance float64
-	queryUpdateUserBalance := `UPDATE users SET current = current - ($1), withdrawn = withdrawn + ($1) WHERE user_id = ($2) RETURNING current`
-	err = tx.QueryRow(queryUpdateUserBalance, sum, userID).Scan(&newBalance)
+	queryGetWithdrawals := "SELECT order_id, sum, status, processed_at, refunded_at, COALESCE(refund_reason, '') AS refund_reason FROM withdrawals" + filter +
+		fmt.Sprintf(" ORDER BY processed_at %s, order_id %s", direction, direction)
+	if q.Limit > 0 {
+		args = append(args, q.Limit+1)
+		queryGetWithdrawals += fmt.Sprintf(" LIMIT ($%d)", len(args))
+	}
+
+	err = r.db.Select(&page.Withdrawals, queryGetWithdrawals, args...)
 	if err != nil {
+		return page, err
+	}
+
+	if q.Limit > 0 && len(page.Withdrawals) > q.Limit {
+		page.Withdrawals = page.Withdrawals[:q.Limit]
+		last := page.Withdrawals[len(page.Withdrawals)-1]
+		page.Next = encodeCursor(last.ProcessedAt, last.OrderID)
+	}
+
+	return page, nil
+}
+
+// ApplyAccrual применяет уведомление системы расчёта. Для уже рассчитанного заказа
+// новое начисление или INVALID проводятся как корректировка.
+func (r *RepoDB) ApplyAccrual(orderID string, status string, accrual float64) error {
+	if !validAccrual(accrual) {
+		return fmt.Errorf("%w: %v", ErrInvalidAccrual, accrual)
+	}
+
+	var current string
+	queryGetOrderStatus := `SELECT status FROM orders WHERE order_id = ($1)`
+	err := r.db.Get(&current, queryGetOrderStatus, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderNotFound
+		}
 		return err
 	}
-	if newBalance < 0 {
-		return ErrInsufficientFunds
+
+	if current == PROCESSED {
+		switch status {
+		case PROCESSED:
+			return r.AdjustAccrual(orderID, accrual, reasonRecalculated, AdjustedByProvider)
+		case INVALID:
+			return r.AdjustAccrual(orderID, 0, reasonRevoked, AdjustedByProvider)
+		}
+	}
+
+	_, err = r.applyAccrual(orderID, status, accrual)
+	return err
+}
+
+func (r *RepoDB) GetDeadLetters() ([]entity.DeadLetter, error) {
+	var deadLetters []entity.DeadLetter
+	queryGetDeadLetters := "SELECT order_id, user_id, attempts, last_error, failed_at FROM dead_letters ORDER BY failed_at ASC"
+	err := r.db.Select(&deadLetters, queryGetDeadLetters)
+	if err != nil {
+		return nil, err
+	}
+
+	return deadLetters, nil
+}
+
+func (r *RepoDB) GetDeadLetter(orderID string) (entity.DeadLetter, error) {
+	var deadLetter entity.DeadLetter
+	queryGetDeadLetter := `SELECT order_id, user_id, attempts, last_error, failed_at FROM dead_letters WHERE order_id = ($1)`
+	err := r.db.Get(&deadLetter, queryGetDeadLetter, orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return deadLetter, ErrDeadLetterNotFound
+		}
+		return deadLetter, err
+	}
+
+	return deadLetter, nil
+}
+
+func (r *RepoDB) RedriveDeadLetter(orderID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
 	}
+	defer rollback(tx)
 
-	queryAddWithdraw := `INSERT INTO withdrawals (order_id, user_id, sum, processed_at) VALUES ($1, $2, $3, $4)`
-	_, err = tx.Exec(queryAddWithdraw, orderID, userID, sum, time.Now().Truncate(time.Second))
+	queryDeleteDeadLetter := `DELETE FROM dead_letters WHERE order_id = ($1)`
+	res, err := tx.Exec(queryDeleteDeadLetter, orderID)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrDeadLetterNotFound
+	}
+
+	queryResetAttempts := `UPDATE orders SET attempts = 0, last_error = '', polls = 0, next_poll_at = NULL, poll_started_at = ($1),
+		lease_owner = NULL, lease_expires_at = NULL WHERE order_id = ($2)`
+	_, err = tx.Exec(queryResetAttempts, time.Now(), orderID)
 	if err != nil {
 		return err
 	}
@@ -277,19 +841,191 @@ func (r *RepoDB) Withdraw(orderID string, userID string, sum float64) error {
 		return err
 	}
 
+	r.wake()
+
 	return nil
 }
 
-func (r *RepoDB) GetWithdrawals(userID string) ([]entity.Withdrawal, error) {
-	var withdrawals []entity.Withdrawal
-	queryGetWithdrawals := "SELECT order_id, sum, processed_at FROM withdrawals WHERE user_id = ($1) ORDER BY processed_at ASC"
+func (r *RepoDB) RedriveDeadLetters() (int, error) {
+	var orderIDs []string
+	err := r.db.Select(&orderIDs, "SELECT order_id FROM dead_letters ORDER BY failed_at ASC")
+	if err != nil {
+		return 0, err
+	}
+
+	redriven := 0
+	for _, orderID := range orderIDs {
+		err := r.RedriveDeadLetter(orderID)
+		if err != nil {
+			if errors.Is(err, ErrDeadLetterNotFound) {
+				continue
+			}
+			return redriven, err
+		}
+		redriven++
+	}
+
+	return redriven, nil
+}
+
+func rollback(tx *sql.Tx) {
+	err := tx.Rollback()
+	if err != nil && !errors.Is(err, sql.ErrTxDone) {
+		logger.Logger.Err(err).Msg("")
+	}
+}
 
-	err := r.db.Select(&withdrawals, queryGetWithdrawals, userID)
+func (r *RepoDB) GetOrdersForReview() ([]entity.ReviewOrder, error) {
+	var orders []entity.ReviewOrder
+	queryGetOrdersForReview := `SELECT order_id, user_id, status, COALESCE(provider, '') AS provider, polls, attempts, last_error, uploaded_at, review_flagged_at
+		FROM orders WHERE review_flagged_at IS NOT NULL ORDER BY review_flagged_at ASC`
+	err := r.db.Select(&orders, queryGetOrdersForReview)
 	if err != nil {
 		return nil, err
 	}
 
-	return withdrawals, nil
+	return orders, nil
+}
+
+func (r *RepoDB) ResumeOrder(orderID string) error {
+	queryResumeOrder := `UPDATE orders SET review_flagged_at = NULL, polls = 0, next_poll_at = NULL, poll_started_at = ($1)
+		WHERE order_id = ($2) AND review_flagged_at IS NOT NULL`
+	res, err := r.db.Exec(queryResumeOrder, time.Now(), orderID)
+	if err != nil {
+		return err
+	}
+	resumed, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if resumed == 0 {
+		return ErrOrderNotFound
+	}
+
+	r.wake()
+
+	return nil
+}
+
+// RefundWithdrawal отменяет списание и возвращает баллы на счёт. Пустой userID - возврат
+// администратором: без проверки владельца и окна возврата.
+func (r *RepoDB) RefundWithdrawal(orderID string, userID string, reason string, window time.Duration) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	var ownerID, status string
+	var sum float64
+	var processedAt time.Time
+	var refundedAt sql.NullTime
+	// после возврата заказ могли оплатить снова: берём действующее списание, а если его нет - последнее возвращённое
+	queryGetWithdrawal := `SELECT user_id::text, sum, status, processed_at, refunded_at FROM withdrawals WHERE order_id = ($1)
+		ORDER BY refunded_at IS NULL DESC, processed_at DESC LIMIT 1 FOR UPDATE`
+	err = tx.QueryRow(queryGetWithdrawal, orderID).Scan(&ownerID, &sum, &status, &processedAt, &refundedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrWithdrawalNotFound
+		}
+		return err
+	}
+
+	refundedBy := RefundedByAdmin
+	if userID != "" {
+		if ownerID != userID {
+			return ErrWithdrawalNotFound
+		}
+		if window > 0 && time.Since(processedAt) > window {
+			return ErrRefundWindowExpired
+		}
+		refundedBy = RefundedByUser
+	}
+	if refundedAt.Valid {
+		return ErrAlreadyRefunded
+	}
+	if status == WithdrawalReview {
+		return ErrWithdrawalInReview
+	}
+
+	err = refund(tx, orderID, ownerID, sum, reason, refundedBy, time.Now().Truncate(time.Second))
+	if err != nil {
+		return err
+	}
+
+	err = r.notifyBalance(tx, ownerID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// refund помечает списание возвращённым и возвращает баллы в израсходованные партии.
+func refund(tx *sql.Tx, orderID string, ownerID string, sum float64, reason string, refundedBy string, now time.Time) error {
+	queryMarkRefunded := `UPDATE withdrawals SET refunded_at = ($1), refund_reason = ($2), refunded_by = ($3) WHERE order_id = ($4) AND refunded_at IS NULL`
+	_, err := tx.Exec(queryMarkRefunded, now, reason, refundedBy, orderID)
+	if err != nil {
+		return err
+	}
+
+	queryRestoreWithdrawn := `UPDATE users SET withdrawn = withdrawn - ($1) WHERE user_id = ($2)`
+	_, err = tx.Exec(queryRestoreWithdrawn, sum, ownerID)
+	if err != nil {
+		return err
+	}
+
+	return restore(tx, ownerID, ConsumedByWithdrawal, orderID, sum)
+}
+
+// ReserveIdempotencyKey закрепляет ключ за запросом. Если ключ уже использован и не истёк,
+// возвращает сохранённый ответ (StatusCode 0 - запрос с этим ключом ещё выполняется) и reserved=false.
+// Незавершённую резервацию старше Idempotency.LockTimeout (процесс упал, не сохранив ответ)
+// перехватывает повтор того же запроса.
+func (r *RepoDB) ReserveIdempotencyKey(userID string, key string, requestHash string, ttl time.Duration) (entity.IdempotentResponse, bool, error) {
+	var resp entity.IdempotentResponse
+	now := time.Now()
+	lockTimeout := r.cfg.Idempotency.LockTimeout
+	if lockTimeout <= 0 {
+		lockTimeout = time.Minute
+	}
+
+	queryReserveKey := `INSERT INTO idempotency_keys (user_id, key, request_hash, created_at, locked_at) VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (user_id, key) DO UPDATE SET request_hash = EXCLUDED.request_hash, status_code = 0, content_type = '', body = NULL,
+			created_at = EXCLUDED.created_at, locked_at = EXCLUDED.locked_at
+		WHERE idempotency_keys.created_at < ($5)
+			OR (idempotency_keys.status_code = 0 AND idempotency_keys.request_hash = EXCLUDED.request_hash
+				AND COALESCE(idempotency_keys.locked_at, idempotency_keys.created_at) < ($6))`
+	res, err := r.db.Exec(queryReserveKey, userID, key, requestHash, now, now.Add(-ttl), now.Add(-lockTimeout))
+	if err != nil {
+		return resp, false, err
+	}
+	reserved, err := res.RowsAffected()
+	if err != nil {
+		return resp, false, err
+	}
+	if reserved == 1 {
+		return resp, true, nil
+	}
+
+	queryGetResponse := `SELECT request_hash, status_code, content_type, COALESCE(body, ''::bytea) AS body FROM idempotency_keys WHERE user_id = ($1) AND key = ($2)`
+	err = r.db.Get(&resp, queryGetResponse, userID, key)
+	if err != nil {
+		return resp, false, err
+	}
+	return resp, false, nil
+}
+
+func (r *RepoDB) SaveIdempotentResponse(userID string, key string, resp entity.IdempotentResponse) error {
+	querySaveResponse := `UPDATE idempotency_keys SET status_code = ($1), content_type = ($2), body = ($3), locked_at = NULL WHERE user_id = ($4) AND key = ($5)`
+	_, err := r.db.Exec(querySaveResponse, resp.StatusCode, resp.ContentType, resp.Body, userID, key)
+	return err
+}
+
+func (r *RepoDB) ReleaseIdempotencyKey(userID string, key string) error {
+	queryReleaseKey := `DELETE FROM idempotency_keys WHERE user_id = ($1) AND key = ($2)`
+	_, err := r.db.Exec(queryReleaseKey, userID, key)
+	return err
 }
 
 func (r *RepoDB) Close() {
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/rules"
+)
+
+// Правило хранится целиком в definition, priority и enabled продублированы в колонках для выборки и сортировки.
+
+func scanRules(rows *sql.Rows) ([]rules.Rule, error) {
+	defer rows.Close()
+
+	var result []rules.Rule
+	for rows.Next() {
+		var id int64
+		var definition []byte
+		if err := rows.Scan(&id, &definition); err != nil {
+			return nil, err
+		}
+		var rule rules.Rule
+		if err := json.Unmarshal(definition, &rule); err != nil {
+			return nil, err
+		}
+		rule.ID = id
+		result = append(result, rule)
+	}
+	return result, rows.Err()
+}
+
+func (r *RepoDB) GetRules() ([]rules.Rule, error) {
+	rows, err := r.db.Query(`SELECT id, definition FROM bonus_rules ORDER BY priority ASC, id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	return scanRules(rows)
+}
+
+func (r *RepoDB) GetRule(ruleID int64) (rules.Rule, error) {
+	var rule rules.Rule
+	var definition []byte
+	queryGetRule := `SELECT definition FROM bonus_rules WHERE id = ($1)`
+	err := r.db.QueryRow(queryGetRule, ruleID).Scan(&definition)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rule, ErrRuleNotFound
+		}
+		return rule, err
+	}
+
+	if err := json.Unmarshal(definition, &rule); err != nil {
+		return rule, err
+	}
+	rule.ID = ruleID
+	return rule, nil
+}
+
+func (r *RepoDB) CreateRule(rule rules.Rule) (rules.Rule, error) {
+	rule.ID = 0
+	definition, err := json.Marshal(rule)
+	if err != nil {
+		return rule, err
+	}
+
+	now := time.Now()
+	queryAddRule := `INSERT INTO bonus_rules (priority, enabled, definition, created_at, updated_at) VALUES ($1, $2, $3, $4, $4) RETURNING id`
+	err = r.db.QueryRow(queryAddRule, rule.Priority, rule.Enabled, definition, now).Scan(&rule.ID)
+	return rule, err
+}
+
+func (r *RepoDB) UpdateRule(rule rules.Rule) error {
+	ruleID := rule.ID
+	rule.ID = 0
+	definition, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+
+	queryUpdateRule := `UPDATE bonus_rules SET priority = ($1), enabled = ($2), definition = ($3), updated_at = ($4) WHERE id = ($5)`
+	res, err := r.db.Exec(queryUpdateRule, rule.Priority, rule.Enabled, definition, time.Now(), ruleID)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+func (r *RepoDB) DeleteRule(ruleID int64) error {
+	res, err := r.db.Exec(`DELETE FROM bonus_rules WHERE id = ($1)`, ruleID)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+// SeedRules заводит правила, только если таблица правил пуста, и возвращает число созданных правил.
+// Так файл правил задаёт начальный набор, а изменения, сделанные через API, не перезаписываются при рестарте.
+func (r *RepoDB) SeedRules(seed []rules.Rule) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer rollback(tx)
+
+	// реплики, стартующие одновременно, не должны завести правила дважды
+	_, err = tx.Exec(`LOCK TABLE bonus_rules IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return 0, err
+	}
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM bonus_rules)`).Scan(&exists)
+	if err != nil || exists {
+		return 0, err
+	}
+
+	now := time.Now()
+	queryAddRule := `INSERT INTO bonus_rules (priority, enabled, definition, created_at, updated_at) VALUES ($1, $2, $3, $4, $4)`
+	for _, rule := range seed {
+		rule.ID = 0
+		definition, err := json.Marshal(rule)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(queryAddRule, rule.Priority, rule.Enabled, definition, now)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(seed), tx.Commit()
+}
+
+func (r *RepoDB) GetRuleFirings(orderID string) ([]entity.RuleFiring, error) {
+	var firings []entity.RuleFiring
+	queryGetFirings := `SELECT rule_id, rule_name, bonus, fired_at FROM rule_firings WHERE order_id = ($1) ORDER BY seq ASC`
+	err := r.db.Select(&firings, queryGetFirings, orderID)
+	if err != nil {
+		return nil, err
+	}
+	return firings, nil
+}
+
+// applyRules проверяет правила акций для заказа, только что переведённого в PROCESSED, начисляет бонусы
+// и записывает сработавшие правила. Выполняется в транзакции finalizeOrder, которая уже заблокировала строку пользователя:
+// от этого зависят счётчики заказов в Facts. Множитель акции применяется к baseAccrual, а не к начислению с коэффициентом уровня.
+func (r *RepoDB) applyRules(tx *sql.Tx, userID string, orderID string, baseAccrual float64, merchant string, processedAt time.Time) error {
+	rows, err := tx.Query(`SELECT id, definition FROM bonus_rules WHERE enabled ORDER BY priority ASC, id ASC`)
+	if err != nil {
+		return err
+	}
+	active, err := scanRules(rows)
+	if err != nil || len(active) == 0 {
+		return err
+	}
+
+	facts := rules.Facts{ProcessedAt: processedAt, Accrual: baseAccrual, Merchant: merchant}
+	monthStart := time.Date(processedAt.Year(), processedAt.Month(), 1, 0, 0, 0, 0, time.UTC)
+	queryGetFacts := `SELECT COUNT(*) FILTER (WHERE order_id <> ($2)),
+			COUNT(*) FILTER (WHERE processed_at >= ($3) AND processed_at < ($4))
+		FROM orders WHERE user_id = ($1) AND status = 'PROCESSED'`
+	err = tx.QueryRow(queryGetFacts, userID, orderID, monthStart, monthStart.AddDate(0, 1, 0)).Scan(&facts.PreviousOrders, &facts.OrdersInMonth)
+	if err != nil {
+		return err
+	}
+
+	for i, result := range rules.Evaluate(active, facts) {
+		queryAddFiring := `INSERT INTO rule_firings (order_id, user_id, rule_id, rule_name, bonus, seq, fired_at) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		_, err = tx.Exec(queryAddFiring, orderID, userID, result.RuleID, result.RuleName, result.Bonus, i, processedAt)
+		if err != nil {
+			return err
+		}
+
+		if result.Bonus > 0 {
+			err = r.credit(tx, userID, orderID, result.Bonus)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package storage
+
+import "time"
+
+// ScheduleConfig задаёт, как часто опрашивать систему расчёта по заказу в зависимости от его статуса.
+// Интервал удваивается после каждого опроса, пока не упрётся в MaxInterval.
+// Заказ, который не завершился за MaxAge, помечается для ручной проверки и больше не опрашивается.
+type ScheduleConfig struct {
+	Intervals       map[string]time.Duration
+	FailureInterval time.Duration
+	RateLimitDelay  time.Duration
+	MaxInterval     time.Duration
+	MaxAge          time.Duration
+}
+
+func (s ScheduleConfig) interval(status string, polls int) time.Duration {
+	base, ok := s.Intervals[status]
+	if !ok {
+		base = s.Intervals[NEW]
+	}
+	return s.grow(base, polls)
+}
+
+func (s ScheduleConfig) backoff(attempts int) time.Duration {
+	return s.grow(s.FailureInterval, attempts-1)
+}
+
+func (s ScheduleConfig) grow(base time.Duration, times int) time.Duration {
+	if base <= 0 {
+		base = time.Second
+	}
+	d := base
+	for i := 0; i < times; i++ {
+		d *= 2
+		if s.MaxInterval > 0 && d >= s.MaxInterval {
+			return s.MaxInterval
+		}
+	}
+	return d
+}
+
+func (s ScheduleConfig) expired(pollStartedAt time.Time, now time.Time) bool {
+	return s.MaxAge > 0 && now.Sub(pollStartedAt) > s.MaxAge
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleConfigGrow(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule ScheduleConfig
+		base     time.Duration
+		times    int
+		want     time.Duration
+	}{
+		{"first poll", ScheduleConfig{}, 5 * time.Second, 0, 5 * time.Second},
+		{"doubles", ScheduleConfig{}, 5 * time.Second, 3, 40 * time.Second},
+		{"capped", ScheduleConfig{MaxInterval: time.Minute}, 5 * time.Second, 4, time.Minute},
+		{"cap reached exactly", ScheduleConfig{MaxInterval: 40 * time.Second}, 5 * time.Second, 3, 40 * time.Second},
+		{"under the cap", ScheduleConfig{MaxInterval: time.Hour}, 5 * time.Second, 3, 40 * time.Second},
+		{"no overflow on many polls", ScheduleConfig{MaxInterval: time.Hour}, 5 * time.Second, 1000, time.Hour},
+		{"zero base", ScheduleConfig{}, 0, 2, 4 * time.Second},
+		{"negative times", ScheduleConfig{}, 5 * time.Second, -1, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.grow(tt.base, tt.times); got != tt.want {
+				t.Errorf("grow(%v, %d) = %v, want %v", tt.base, tt.times, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleConfigInterval(t *testing.T) {
+	schedule := ScheduleConfig{
+		Intervals:       map[string]time.Duration{NEW: 5 * time.Second, REGISTERED: 10 * time.Second},
+		FailureInterval: 2 * time.Second,
+		MaxInterval:     time.Minute,
+	}
+
+	if got := schedule.interval(REGISTERED, 1); got != 20*time.Second {
+		t.Errorf("interval(REGISTERED, 1) = %v, want 20s", got)
+	}
+	if got := schedule.interval(PROCESSING, 0); got != 5*time.Second {
+		t.Errorf("interval(PROCESSING, 0) = %v, want NEW interval 5s", got)
+	}
+	if got := schedule.backoff(1); got != 2*time.Second {
+		t.Errorf("backoff(1) = %v, want 2s", got)
+	}
+	if got := schedule.backoff(3); got != 8*time.Second {
+		t.Errorf("backoff(3) = %v, want 8s", got)
+	}
+}
+
+func TestScheduleConfigExpired(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		maxAge time.Duration
+		start  time.Time
+		want   bool
+	}{
+		{"no max age", 0, now.Add(-1000 * time.Hour), false},
+		{"within max age", time.Hour, now.Add(-time.Hour), false},
+		{"past max age", time.Hour, now.Add(-time.Hour - time.Second), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (ScheduleConfig{MaxAge: tt.maxAge}).expired(tt.start, now); got != tt.want {
+				t.Errorf("expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
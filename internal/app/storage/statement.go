@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+)
+
+const queryStatementEntries = `SELECT at, kind, order_id, amount FROM balance_history WHERE user_id = ($1)`
+
+// StreamStatement построчно отдаёт выписку за [from, to) в хронологическом порядке с нарастающим балансом,
+// не загружая её целиком в память. Нулевые from и to означают отсутствие границы.
+// Баланс - чистый баланс счёта (current - debt). Входящий остаток считается от фактического баланса назад,
+// а не суммой истории с начала: так в него попадают и движения, которых нет в balance_history
+// (баланс до появления истории, погашение долга), и выписка без to заканчивается фактическим балансом.
+func (r *RepoDB) StreamStatement(userID string, from time.Time, to time.Time, sw StatementWriter) error {
+	// баланс и записи читаются из одного снимка, иначе начисление между запросами сдвинет остаток
+	tx, err := r.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	var opening float64
+	queryOpeningBalance := `SELECT u.current - u.debt - COALESCE((SELECT SUM(amount) FROM (` + queryStatementEntries + `) e WHERE at >= ($2)), 0)
+		FROM users u WHERE u.user_id = ($1)`
+	err = tx.QueryRow(queryOpeningBalance, userID, from).Scan(&opening)
+	if err != nil {
+		return err
+	}
+	opening = math.Round(opening*100) / 100
+
+	if to.IsZero() {
+		to = time.Now().Add(time.Second)
+	}
+
+	queryEntries := `SELECT at, kind, order_id, amount FROM (` + queryStatementEntries + `) e
+		WHERE at >= ($2) AND at < ($3) ORDER BY at ASC, kind ASC, order_id ASC`
+	rows, err := tx.Query(queryEntries, userID, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if err := sw.Begin(opening); err != nil {
+		return err
+	}
+
+	balance := opening
+	for rows.Next() {
+		var entry entity.StatementEntry
+		var at time.Time
+		if err := rows.Scan(&at, &entry.Type, &entry.OrderID, &entry.Amount); err != nil {
+			return err
+		}
+		balance = math.Round((balance+entry.Amount)*100) / 100
+		entry.Date = at.Format(time.RFC3339)
+		entry.Balance = balance
+
+		if err := sw.Entry(entry); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return sw.End(balance)
+}
@@ -2,21 +2,134 @@ package storage
 
 import (
 	"errors"
+	"time"
 
 	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/events"
+	"github.com/devkekops/gophermart/internal/app/rules"
 )
 
 var ErrOrderExistsForCurrentUser = errors.New("order already been loaded by current user")
 var ErrOrderExistsForOtherUser = errors.New("order already been loaded by other user")
 var ErrInsufficientFunds = errors.New("insufficient funds")
+var ErrWithdrawalExists = errors.New("withdrawal for order already exists")
+var ErrWithdrawalNotFound = errors.New("withdrawal not found")
+var ErrAlreadyRefunded = errors.New("withdrawal already refunded")
+var ErrRefundWindowExpired = errors.New("refund window expired")
+var ErrWithdrawalLimitExceeded = errors.New("withdrawal limit exceeded")
+var ErrAccountTooNew = errors.New("account too new to withdraw")
+var ErrWithdrawalInReview = errors.New("withdrawal is under review")
+var ErrWithdrawalNotInReview = errors.New("withdrawal is not under review")
+var ErrHoldNotFound = errors.New("hold not found")
+var ErrHoldExpired = errors.New("hold expired")
+var ErrCaptureExceedsHold = errors.New("capture sum exceeds hold")
+var ErrAPIKeyNotFound = errors.New("api key not found")
+var ErrInvalidAPIKey = errors.New("invalid api key")
+var ErrOrderNotFound = errors.New("order not found")
+var ErrOrderNotProcessed = errors.New("order is not processed")
+var ErrUnknownAccrualStatus = errors.New("unknown accrual status")
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+var ErrRecipientNotFound = errors.New("transfer recipient not found")
+var ErrSelfTransfer = errors.New("transfer to self")
+var ErrTransferLimitExceeded = errors.New("daily transfer limit exceeded")
+var ErrTransferNotFound = errors.New("transfer not found")
+var ErrTransferNotPending = errors.New("transfer is not pending")
+var ErrRuleNotFound = errors.New("bonus rule not found")
+var ErrUnknownReferralCode = errors.New("unknown referral code")
+var ErrVoucherNotFound = errors.New("voucher not found")
+var ErrVoucherExpired = errors.New("voucher expired")
+var ErrVoucherExhausted = errors.New("voucher usage limit reached")
+var ErrVoucherAlreadyRedeemed = errors.New("voucher already redeemed by user")
+var ErrInvalidSum = errors.New("sum must be a positive number")
+var ErrInvalidAccrual = errors.New("accrual must be a non-negative number")
+
+// OrdersQuery - фильтры и keyset-пагинация списка заказов.
+// Нулевые значения полей означают отсутствие фильтра, Limit 0 - все заказы.
+type OrdersQuery struct {
+	Statuses []string
+	From     time.Time
+	To       time.Time
+	Desc     bool
+	Limit    int
+	Cursor   string
+}
+
+// WithdrawalsQuery - фильтры и keyset-пагинация списка списаний.
+// Нулевые значения полей означают отсутствие фильтра, Limit 0 - все списания.
+type WithdrawalsQuery struct {
+	From   time.Time
+	To     time.Time
+	MinSum float64
+	MaxSum float64
+	Desc   bool
+	Limit  int
+	Cursor string
+}
+
+// WithdrawalsPage - страница списаний и итоги по всему отфильтрованному окну.
+type WithdrawalsPage struct {
+	Withdrawals []entity.Withdrawal
+	Totals      entity.WithdrawalTotals
+	Next        string
+}
+
+// StatementWriter получает выписку по частям: входящий остаток, записи по одной и исходящий остаток.
+type StatementWriter interface {
+	Begin(opening float64) error
+	Entry(entry entity.StatementEntry) error
+	End(closing float64) error
+}
 
 type Repository interface {
-	CreateUser(login string, passwordHash string) (string, error)
+	CreateUser(login string, passwordHash string, referralCode string) (string, error)
 	AuthUser(login string, passwordHash string) (string, error)
-	LoadOrder(orderID string, userID string) error
-	GetOrders(userID string) ([]entity.Order, error)
+	LoadOrder(orderID string, userID string, merchant string) error
+	LoadOrders(orderIDs []string, userID string, merchant string) ([]entity.OrderUploadResult, error)
+	GetOrders(userID string, q OrdersQuery) ([]entity.Order, string, error)
 	GetBalance(userID string) (entity.Balance, error)
-	Withdraw(orderID string, userID string, sum float64) error
-	GetWithdrawals(userID string) ([]entity.Withdrawal, error)
+	GetProfile(userID string) (entity.Profile, error)
+	GetReferrals(userID string) (entity.Referrals, error)
+	Withdraw(orderID string, userID string, sum float64) (string, error)
+	AuthorizeWithdrawal(orderID string, userID string, sum float64) (entity.Hold, error)
+	CaptureWithdrawal(orderID string, userID string, sum float64) (string, error)
+	VoidWithdrawal(orderID string, userID string) error
+	GetHolds(userID string) ([]entity.Hold, error)
+	Subscribe(userID string) (<-chan events.Event, func())
+	CreateAPIKey(userID string, name string) (entity.APIKey, error)
+	GetAPIKeys(userID string) ([]entity.APIKey, error)
+	RevokeAPIKey(userID string, keyID int64) error
+	AuthenticateAPIKey(key string) (string, error)
+	GetWithdrawals(userID string, q WithdrawalsQuery) (WithdrawalsPage, error)
+	RefundWithdrawal(orderID string, userID string, reason string, window time.Duration) error
+	Transfer(userID string, toLogin string, sum float64, comment string) (entity.Transfer, error)
+	ResolveTransfer(userID string, transferID int64, action string) error
+	GetTransfers(userID string) ([]entity.Transfer, error)
+	SetTransferConfirmation(userID string, required bool) error
+	RedeemVoucher(userID string, code string) (entity.Redemption, error)
+	GetHistory(userID string, q HistoryQuery) ([]entity.HistoryEntry, string, error)
+	StreamStatement(userID string, from time.Time, to time.Time, sw StatementWriter) error
+	ApplyAccrual(orderID string, status string, accrual float64) error
+	AdjustAccrual(orderID string, accrual float64, reason string, adjustedBy string) error
+	GetDeadLetters() ([]entity.DeadLetter, error)
+	GetDeadLetter(orderID string) (entity.DeadLetter, error)
+	RedriveDeadLetter(orderID string) error
+	RedriveDeadLetters() (int, error)
+	GetOrdersForReview() ([]entity.ReviewOrder, error)
+	GetWithdrawalsForReview() ([]entity.ReviewWithdrawal, error)
+	ApproveWithdrawal(orderID string) error
+	RejectWithdrawal(orderID string, reason string) error
+	GetRules() ([]rules.Rule, error)
+	GetRule(ruleID int64) (rules.Rule, error)
+	CreateRule(rule rules.Rule) (rules.Rule, error)
+	UpdateRule(rule rules.Rule) error
+	DeleteRule(ruleID int64) error
+	GetRuleFirings(orderID string) ([]entity.RuleFiring, error)
+	MintVouchers(count int, value float64, usageLimit int, expiresAt *time.Time) (entity.VoucherBatch, error)
+	GetVoucherBatches() ([]entity.VoucherBatch, error)
+	ResumeOrder(orderID string) error
+	ReserveIdempotencyKey(userID string, key string, requestHash string, ttl time.Duration) (entity.IdempotentResponse, bool, error)
+	SaveIdempotentResponse(userID string, key string, resp entity.IdempotentResponse) error
+	ReleaseIdempotencyKey(userID string, key string) error
 	Close()
 }
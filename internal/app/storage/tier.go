@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+// queryWindowAccrued - начисления системы расчёта (без повышающего коэффициента) за скользящее окно.
+const queryWindowAccrued = `SELECT COALESCE(SUM(COALESCE(base_accrual, accrual)), 0) FROM orders
+	WHERE user_id = ($1) AND status = 'PROCESSED' AND processed_at >= ($2)`
+
+// multiplier возвращает коэффициент текущего уровня пользователя и блокирует его строку до конца транзакции.
+func (r *RepoDB) multiplier(tx *sql.Tx, userID string) (float64, error) {
+	var tier string
+	queryGetTier := `SELECT COALESCE(tier, '') FROM users WHERE user_id = ($1) FOR UPDATE`
+	err := tx.QueryRow(queryGetTier, userID).Scan(&tier)
+	if err != nil {
+		return 0, err
+	}
+	return r.cfg.Tiers.ByName(tier).Multiplier, nil
+}
+
+// updateTier пересчитывает уровень пользователя сразу после начисления, не дожидаясь tierLoop.
+func (r *RepoDB) updateTier(tx *sql.Tx, userID string) error {
+	var accrued float64
+	err := tx.QueryRow(queryWindowAccrued, userID, r.cfg.Tiers.WindowStart(time.Now())).Scan(&accrued)
+	if err != nil {
+		return err
+	}
+
+	queryUpdateTier := `UPDATE users SET tier = ($1) WHERE user_id = ($2) AND tier IS DISTINCT FROM ($1)`
+	_, err = tx.Exec(queryUpdateTier, r.cfg.Tiers.Tier(accrued).Name, userID)
+	return err
+}
+
+func (r *RepoDB) tierLoop() {
+	interval := r.cfg.TierRecalcInterval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := r.recalcTiers(time.Now()); err != nil {
+			logger.Logger.Err(err).Msg("")
+		}
+	}
+}
+
+// recalcTiers понижает уровни, когда старые начисления выходят из окна, и применяет изменения конфигурации уровней.
+func (r *RepoDB) recalcTiers(now time.Time) error {
+	queryGetAccrued := `SELECT u.user_id::text, COALESCE(u.tier, ''), COALESCE(SUM(COALESCE(o.base_accrual, o.accrual)), 0)
+		FROM users u LEFT JOIN orders o ON o.user_id = u.user_id AND o.status = 'PROCESSED' AND o.processed_at >= ($1)
+		GROUP BY u.user_id`
+	rows, err := r.db.Query(queryGetAccrued, r.cfg.Tiers.WindowStart(now))
+	if err != nil {
+		return err
+	}
+
+	type change struct{ from, to string }
+	changed := make(map[string]change)
+	for rows.Next() {
+		var userID, tier string
+		var accrued float64
+		if err := rows.Scan(&userID, &tier, &accrued); err != nil {
+			rows.Close()
+			return err
+		}
+		if newTier := r.cfg.Tiers.Tier(accrued).Name; newTier != tier {
+			changed[userID] = change{from: tier, to: newTier}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// выборка могла устареть: если начисление успело пересчитать уровень в updateTier, его не перетираем
+	for userID, c := range changed {
+		queryUpdateTier := `UPDATE users SET tier = ($1) WHERE user_id = ($2) AND COALESCE(tier, '') = ($3)`
+		_, err := r.db.Exec(queryUpdateTier, c.to, userID, c.from)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetProfile показывает сохранённый уровень, по которому идут начисления, и прогресс до следующего.
+// Понижение уровня, когда начисления выходят из окна, появится после ближайшего recalcTiers.
+func (r *RepoDB) GetProfile(userID string) (entity.Profile, error) {
+	var profile entity.Profile
+	var tierName string
+	queryGetUser := `SELECT login, COALESCE(tier, '') FROM users WHERE user_id = ($1)`
+	err := r.db.QueryRow(queryGetUser, userID).Scan(&profile.Login, &tierName)
+	if err != nil {
+		return profile, err
+	}
+
+	since := r.cfg.Tiers.WindowStart(time.Now())
+	err = r.db.Get(&profile.Accrued, queryWindowAccrued, userID, since)
+	if err != nil {
+		return profile, err
+	}
+
+	tier := r.cfg.Tiers.ByName(tierName)
+	profile.Tier = tier.Name
+	profile.Multiplier = tier.Multiplier
+	profile.Since = since.Format(time.RFC3339)
+	if next, ok := r.cfg.Tiers.Next(tier); ok {
+		profile.NextTier = next.Name
+		profile.NextThreshold = next.Threshold
+		profile.ToNextTier = roundPoints(math.Max(next.Threshold-profile.Accrued, 0))
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,397 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+const (
+	TransferPending   = "PENDING"
+	TransferCompleted = "COMPLETED"
+	TransferDeclined  = "DECLINED"
+	TransferCanceled  = "CANCELED"
+	// TransferExpired - получатель не принял перевод за PendingTTL, баллы вернулись отправителю.
+	TransferExpired = "EXPIRED"
+)
+
+const transferExpiryBatch = 100
+
+const (
+	TransferAccept  = "accept"
+	TransferDecline = "decline"
+	TransferCancel  = "cancel"
+)
+
+// TransferLimits ограничивает исходящие переводы пользователя за скользящие 24 часа,
+// нулевые значения - без ограничения. Перевод, ожидающий подтверждения дольше PendingTTL,
+// отменяется transferExpiryLoop-ом с периодом ExpiryInterval; нулевой PendingTTL - без срока.
+type TransferLimits struct {
+	DailySum       float64
+	DailyCount     int
+	PendingTTL     time.Duration
+	ExpiryInterval time.Duration
+}
+
+// transferRef - номер перевода в истории, партиях и расходах партий.
+func transferRef(id int64) string {
+	return "transfer-" + strconv.FormatInt(id, 10)
+}
+
+// lockUsers блокирует строки пользователей всегда в порядке user_id, чтобы встречные переводы не взаимоблокировались.
+func lockUsers(tx *sql.Tx, userIDs ...string) error {
+	queryLockUsers := `SELECT user_id FROM users WHERE user_id = ANY(($1::text[])::int[]) ORDER BY user_id FOR UPDATE`
+	rows, err := tx.Query(queryLockUsers, userIDs)
+	if err != nil {
+		return err
+	}
+	rows.Close()
+	return rows.Err()
+}
+
+// Transfer переводит sum получателю с логином toLogin. Если получатель требует подтверждения,
+// баллы списываются сразу, а зачисляются после принятия перевода (ResolveTransfer);
+// при отказе или отмене они возвращаются отправителю.
+func (r *RepoDB) Transfer(userID string, toLogin string, sum float64, comment string) (entity.Transfer, error) {
+	var transfer entity.Transfer
+	if !validSum(sum) {
+		return transfer, ErrInvalidSum
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return transfer, err
+	}
+	defer rollback(tx)
+
+	var recipientID string
+	var confirm bool
+	queryGetRecipient := `SELECT user_id::text, require_transfer_confirmation FROM users WHERE login = ($1)`
+	err = tx.QueryRow(queryGetRecipient, toLogin).Scan(&recipientID, &confirm)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return transfer, ErrRecipientNotFound
+		}
+		return transfer, err
+	}
+	if recipientID == userID {
+		return transfer, ErrSelfTransfer
+	}
+
+	err = lockUsers(tx, userID, recipientID)
+	if err != nil {
+		return transfer, err
+	}
+
+	now := time.Now()
+	err = r.checkTransferLimits(tx, userID, sum, now)
+	if err != nil {
+		return transfer, err
+	}
+
+	var newBalance float64
+	queryDebitSender := `UPDATE users SET current = current - ($1) WHERE user_id = ($2) RETURNING current`
+	err = tx.QueryRow(queryDebitSender, sum, userID).Scan(&newBalance)
+	if err != nil {
+		return transfer, err
+	}
+	if newBalance < 0 {
+		return transfer, ErrInsufficientFunds
+	}
+
+	transfer = entity.Transfer{
+		Direction:   entity.TransferOut,
+		Counterpart: toLogin,
+		Sum:         sum,
+		Comment:     comment,
+		Status:      TransferCompleted,
+		CreatedAt:   now.Format(time.RFC3339),
+	}
+	var resolvedAt *time.Time
+	if confirm {
+		transfer.Status = TransferPending
+	} else {
+		resolvedAt = &now
+		transfer.ResolvedAt = &transfer.CreatedAt
+	}
+
+	queryAddTransfer := `INSERT INTO transfers (sender_id, recipient_id, sum, comment, status, created_at, resolved_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err = tx.QueryRow(queryAddTransfer, userID, recipientID, sum, comment, transfer.Status, now, resolvedAt).Scan(&transfer.ID)
+	if err != nil {
+		return transfer, err
+	}
+
+	ref := transferRef(transfer.ID)
+	err = consume(tx, userID, ConsumedByTransfer, ref, sum)
+	if err != nil {
+		return transfer, err
+	}
+
+	if !confirm {
+		err = r.receive(tx, recipientID, ref, sum)
+		if err != nil {
+			return transfer, err
+		}
+	}
+
+	for _, uid := range []string{userID, recipientID} {
+		if err := r.notifyBalance(tx, uid); err != nil {
+			return transfer, err
+		}
+	}
+
+	return transfer, tx.Commit()
+}
+
+func (r *RepoDB) checkTransferLimits(tx *sql.Tx, userID string, sum float64, now time.Time) error {
+	limits := r.cfg.Transfers
+	if limits.DailySum <= 0 && limits.DailyCount <= 0 {
+		return nil
+	}
+
+	var count int
+	var total float64
+	queryGetDailyTotals := `SELECT COUNT(*), COALESCE(SUM(sum), 0) FROM transfers
+		WHERE sender_id = ($1) AND created_at > ($2) AND status NOT IN ($3, $4, $5)`
+	err := tx.QueryRow(queryGetDailyTotals, userID, now.Add(-24*time.Hour), TransferDeclined, TransferCanceled, TransferExpired).Scan(&count, &total)
+	if err != nil {
+		return err
+	}
+
+	if limits.exceeded(count, total, sum) {
+		return ErrTransferLimitExceeded
+	}
+	return nil
+}
+
+// exceeded сообщает, превысит ли перевод sum лимиты, если за последние 24 часа уже сделано count переводов на total.
+func (limits TransferLimits) exceeded(count int, total float64, sum float64) bool {
+	if limits.DailyCount > 0 && count+1 > limits.DailyCount {
+		return true
+	}
+	return limits.DailySum > 0 && roundPoints(total+sum) > limits.DailySum
+}
+
+// receive зачисляет перевод получателю: гасит его долг, а остаток кладёт в партии с теми же сроками сгорания,
+// что и у израсходованных партий отправителя, чтобы перевод не продлевал жизнь баллов.
+func (r *RepoDB) receive(tx *sql.Tx, recipientID string, ref string, sum float64) error {
+	rest, err := payDebt(tx, recipientID, sum)
+	if err != nil || rest <= 0 {
+		return err
+	}
+
+	type chunk struct {
+		amount    float64
+		expiresAt sql.NullTime
+	}
+	queryGetChunks := `SELECT c.amount, l.expires_at FROM lot_consumptions c JOIN point_lots l ON l.id = c.lot_id
+		WHERE c.kind = ($1) AND c.ref = ($2) ORDER BY l.expires_at ASC NULLS LAST, l.id ASC`
+	rows, err := tx.Query(queryGetChunks, ConsumedByTransfer, ref)
+	if err != nil {
+		return err
+	}
+	var chunks []chunk
+	for rows.Next() {
+		var c chunk
+		if err := rows.Scan(&c.amount, &c.expiresAt); err != nil {
+			rows.Close()
+			return err
+		}
+		chunks = append(chunks, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, c := range chunks {
+		if rest <= 0 {
+			return nil
+		}
+		amount := math.Min(c.amount, rest)
+		rest = roundPoints(rest - amount)
+
+		var expiresAt *time.Time
+		if c.expiresAt.Valid {
+			expiresAt = &c.expiresAt.Time
+		}
+		if err := addLot(tx, recipientID, ref, amount, now, expiresAt); err != nil {
+			return err
+		}
+	}
+
+	// часть перевода из баланса без партий получает обычный срок жизни
+	if rest > 0 {
+		return addLot(tx, recipientID, ref, rest, now, r.lotExpiry(now))
+	}
+	return nil
+}
+
+// ResolveTransfer завершает ожидающий перевод: получатель принимает или отклоняет его, отправитель может отменить.
+func (r *RepoDB) ResolveTransfer(userID string, transferID int64, action string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	var senderID, recipientID, status string
+	var sum float64
+	queryGetTransfer := `SELECT sender_id::text, recipient_id::text, sum, status FROM transfers WHERE id = ($1) FOR UPDATE`
+	err = tx.QueryRow(queryGetTransfer, transferID).Scan(&senderID, &recipientID, &sum, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrTransferNotFound
+		}
+		return err
+	}
+
+	party := recipientID
+	if action == TransferCancel {
+		party = senderID
+	}
+	if party != userID {
+		return ErrTransferNotFound
+	}
+	if status != TransferPending {
+		return ErrTransferNotPending
+	}
+
+	switch action {
+	case TransferAccept:
+		status = TransferCompleted
+	case TransferDecline:
+		status = TransferDeclined
+	case TransferCancel:
+		status = TransferCanceled
+	}
+
+	err = r.finishTransfer(tx, transferID, senderID, recipientID, sum, status)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// finishTransfer закрывает ожидающий перевод со статусом status: COMPLETED зачисляет его получателю,
+// остальные статусы возвращают баллы отправителю.
+func (r *RepoDB) finishTransfer(tx *sql.Tx, transferID int64, senderID string, recipientID string, sum float64, status string) error {
+	err := lockUsers(tx, senderID, recipientID)
+	if err != nil {
+		return err
+	}
+
+	ref := transferRef(transferID)
+	if status == TransferCompleted {
+		err = r.receive(tx, recipientID, ref, sum)
+	} else {
+		err = restore(tx, senderID, ConsumedByTransfer, ref, sum)
+	}
+	if err != nil {
+		return err
+	}
+
+	queryResolveTransfer := `UPDATE transfers SET status = ($1), resolved_at = ($2) WHERE id = ($3)`
+	_, err = tx.Exec(queryResolveTransfer, status, time.Now(), transferID)
+	if err != nil {
+		return err
+	}
+
+	for _, uid := range []string{senderID, recipientID} {
+		if err := r.notifyBalance(tx, uid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RepoDB) transferExpiryLoop() {
+	if r.cfg.Transfers.PendingTTL <= 0 {
+		return
+	}
+	interval := r.cfg.Transfers.ExpiryInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := r.expireTransfers(time.Now()); err != nil {
+			logger.Logger.Err(err).Msg("")
+		}
+	}
+}
+
+// expireTransfers возвращает отправителям переводы, которые ждут подтверждения дольше PendingTTL,
+// каждый в своей транзакции; переводы, которые в этот момент принимает или отменяет пользователь, пропускаются.
+func (r *RepoDB) expireTransfers(now time.Time) error {
+	var transferIDs []int64
+	queryGetExpired := `SELECT id FROM transfers WHERE status = ($1) AND created_at <= ($2) ORDER BY created_at ASC LIMIT ($3)`
+	err := r.db.Select(&transferIDs, queryGetExpired, TransferPending, now.Add(-r.cfg.Transfers.PendingTTL), transferExpiryBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, transferID := range transferIDs {
+		if err := r.expireTransfer(transferID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RepoDB) expireTransfer(transferID int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	var senderID, recipientID string
+	var sum float64
+	queryGetTransfer := `SELECT sender_id::text, recipient_id::text, sum FROM transfers WHERE id = ($1) AND status = ($2) FOR UPDATE SKIP LOCKED`
+	err = tx.QueryRow(queryGetTransfer, transferID, TransferPending).Scan(&senderID, &recipientID, &sum)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	err = r.finishTransfer(tx, transferID, senderID, recipientID, sum, TransferExpired)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *RepoDB) GetTransfers(userID string) ([]entity.Transfer, error) {
+	var transfers []entity.Transfer
+	queryGetTransfers := `SELECT t.id, CASE WHEN t.sender_id = ($1) THEN 'out' ELSE 'in' END AS direction, u.login AS counterpart,
+			t.sum, t.comment, t.status, t.created_at, t.resolved_at
+		FROM transfers t JOIN users u ON u.user_id = CASE WHEN t.sender_id = ($1) THEN t.recipient_id ELSE t.sender_id END
+		WHERE t.sender_id = ($1) OR t.recipient_id = ($1)
+		ORDER BY t.created_at DESC, t.id DESC`
+	err := r.db.Select(&transfers, queryGetTransfers, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+func (r *RepoDB) SetTransferConfirmation(userID string, required bool) error {
+	querySetConfirmation := `UPDATE users SET require_transfer_confirmation = ($1) WHERE user_id = ($2)`
+	_, err := r.db.Exec(querySetConfirmation, required, userID)
+	return err
+}
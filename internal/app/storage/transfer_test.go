@@ -0,0 +1,30 @@
+package storage
+
+import "testing"
+
+func TestTransferLimitsExceeded(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits TransferLimits
+		count  int
+		total  float64
+		sum    float64
+		want   bool
+	}{
+		{"no limits", TransferLimits{}, 100, 1e6, 1e6, false},
+		{"under both limits", TransferLimits{DailySum: 1000, DailyCount: 3}, 1, 400, 500, false},
+		{"sum reaches the limit", TransferLimits{DailySum: 1000}, 1, 400, 600, false},
+		{"sum over the limit", TransferLimits{DailySum: 1000}, 1, 400, 600.01, true},
+		{"count reaches the limit", TransferLimits{DailyCount: 3}, 2, 0, 1, false},
+		{"count over the limit", TransferLimits{DailyCount: 3}, 3, 0, 1, true},
+		{"rounding", TransferLimits{DailySum: 0.3}, 1, 0.1, 0.2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limits.exceeded(tt.count, tt.total, tt.sum); got != tt.want {
+				t.Errorf("exceeded(%d, %v, %v) = %v, want %v", tt.count, tt.total, tt.sum, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+)
+
+// voucherCodeBytes - 80 бит случайности на код: подбор перебором через API нереален.
+const voucherCodeBytes = 10
+
+// Коды ваучеров хранятся только в виде sha256, открытые коды отдаются один раз при выпуске.
+
+func newVoucherCode() (string, error) {
+	buf := make([]byte, voucherCodeBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.EncodeToString(buf)
+
+	groups := make([]string, 0, len(code)/4)
+	for i := 0; i < len(code); i += 4 {
+		groups = append(groups, code[i:i+4])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// voucherHash приводит код к каноническому виду (без дефисов и пробелов, в верхнем регистре) и хеширует его.
+func voucherHash(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func voucherRef(batchID int64) string {
+	return "voucher-" + strconv.FormatInt(batchID, 10)
+}
+
+// MintVouchers выпускает партию из count ваучеров номиналом value. Каждый код можно погасить
+// usageLimit раз (разными пользователями) до expiresAt; nil expiresAt - бессрочно.
+func (r *RepoDB) MintVouchers(count int, value float64, usageLimit int, expiresAt *time.Time) (entity.VoucherBatch, error) {
+	batch := entity.VoucherBatch{Count: count, Value: value, UsageLimit: usageLimit}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return batch, err
+	}
+	defer rollback(tx)
+
+	now := time.Now()
+	queryAddBatch := `INSERT INTO voucher_batches (value, usage_limit, expires_at, count, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	err = tx.QueryRow(queryAddBatch, value, usageLimit, expiresAt, count, now).Scan(&batch.ID)
+	if err != nil {
+		return batch, err
+	}
+
+	codes := make([]string, 0, count)
+	hashes := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		code, err := newVoucherCode()
+		if err != nil {
+			return batch, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, voucherHash(code))
+	}
+
+	queryAddVouchers := `INSERT INTO vouchers (code_hash, batch_id) SELECT unnest($1::text[]), $2`
+	_, err = tx.Exec(queryAddVouchers, hashes, batch.ID)
+	if err != nil {
+		return batch, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return batch, err
+	}
+
+	batch.Codes = codes
+	batch.CreatedAt = now.Format(time.RFC3339)
+	if expiresAt != nil {
+		formatted := expiresAt.Format(time.RFC3339)
+		batch.ExpiresAt = &formatted
+	}
+	return batch, nil
+}
+
+func (r *RepoDB) GetVoucherBatches() ([]entity.VoucherBatch, error) {
+	var batches []entity.VoucherBatch
+	queryGetBatches := `SELECT b.id, b.value, b.usage_limit, b.expires_at, b.count, b.created_at, COALESCE(SUM(v.uses), 0) AS redeemed
+		FROM voucher_batches b LEFT JOIN vouchers v ON v.batch_id = b.id
+		GROUP BY b.id ORDER BY b.created_at DESC, b.id DESC`
+	err := r.db.Select(&batches, queryGetBatches)
+	if err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// RedeemVoucher гасит ваучер и зачисляет его номинал. Счётчик использований увеличивается условным UPDATE-ом,
+// поэтому параллельные погашения не превысят лимит, а уникальный ключ (код, пользователь)
+// не даст одному пользователю погасить код дважды.
+func (r *RepoDB) RedeemVoucher(userID string, code string) (entity.Redemption, error) {
+	var redemption entity.Redemption
+	tx, err := r.db.Begin()
+	if err != nil {
+		return redemption, err
+	}
+	defer rollback(tx)
+
+	hash := voucherHash(code)
+	now := time.Now()
+
+	var batchID int64
+	queryUseVoucher := `UPDATE vouchers v SET uses = v.uses + 1 FROM voucher_batches b
+		WHERE v.code_hash = ($1) AND b.id = v.batch_id AND v.uses < b.usage_limit AND (b.expires_at IS NULL OR b.expires_at > ($2))
+		RETURNING b.id, b.value`
+	err = tx.QueryRow(queryUseVoucher, hash, now).Scan(&batchID, &redemption.Value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return redemption, r.voucherUnavailable(tx, hash, now)
+		}
+		return redemption, err
+	}
+
+	queryAddRedemption := `INSERT INTO voucher_redemptions (code_hash, user_id, batch_id, value, redeemed_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.Exec(queryAddRedemption, hash, userID, batchID, redemption.Value, now)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return redemption, ErrVoucherAlreadyRedeemed
+		}
+		return redemption, err
+	}
+
+	err = r.credit(tx, userID, voucherRef(batchID), redemption.Value)
+	if err != nil {
+		return redemption, err
+	}
+
+	err = r.notifyBalance(tx, userID)
+	if err != nil {
+		return redemption, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return redemption, err
+	}
+
+	redemption.RedeemedAt = now.Format(time.RFC3339)
+	return redemption, nil
+}
+
+// voucherUnavailable объясняет, почему код не удалось погасить.
+func (r *RepoDB) voucherUnavailable(tx *sql.Tx, hash string, now time.Time) error {
+	var expiresAt sql.NullTime
+	queryGetVoucher := `SELECT b.expires_at FROM vouchers v JOIN voucher_batches b ON b.id = v.batch_id WHERE v.code_hash = ($1)`
+	err := tx.QueryRow(queryGetVoucher, hash).Scan(&expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrVoucherNotFound
+		}
+		return err
+	}
+	if expiresAt.Valid && !expiresAt.Time.After(now) {
+		return ErrVoucherExpired
+	}
+	return ErrVoucherExhausted
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/entity"
+)
+
+const (
+	WithdrawalProcessed = "PROCESSED"
+	WithdrawalReview    = "REVIEW"
+	WithdrawalRejected  = "REJECTED"
+)
+
+const reviewLargeSum = "sum above review threshold"
+
+// WithdrawalLimits - ограничения на списания. Превышение лимитов и слишком новый аккаунт отклоняют списание,
+// а подозрительное (частые или крупные списания) проходит, но ждёт решения администратора:
+// баллы на это время уже списаны. Дневные лимиты, как и у переводов, считаются за скользящие 24 часа.
+// Нулевые значения - без ограничения.
+type WithdrawalLimits struct {
+	MaxSum        float64
+	DailySum      float64
+	DailyCount    int
+	MinAccountAge time.Duration
+	// VelocityCount списаний за VelocityWindow отправляют следующее на проверку.
+	VelocityCount  int
+	VelocityWindow time.Duration
+	ReviewSum      float64
+}
+
+// checkWithdrawal проверяет списание по лимитам и возвращает причину ручной проверки или пустую строку.
+// Вызывается под блокировкой строки пользователя, поэтому параллельные списания не обходят лимиты.
+func (r *RepoDB) checkWithdrawal(tx *sql.Tx, userID string, sum float64, registeredAt sql.NullTime, now time.Time) (string, error) {
+	limits := r.cfg.Withdrawals
+	if err := limits.checkRequest(sum, registeredAt, now); err != nil {
+		return "", err
+	}
+
+	var totals withdrawalTotals
+	// активные блокировки учитываются наравне со списаниями
+	queryGetTotals := `SELECT COUNT(*) FILTER (WHERE at > ($4)), COALESCE(SUM(sum) FILTER (WHERE at > ($4)), 0),
+			COUNT(*) FILTER (WHERE at >= ($5))
+		FROM (
+			SELECT sum, processed_at AS at FROM withdrawals WHERE user_id = ($1) AND status <> ($2)
+			UNION ALL
+			SELECT sum, created_at FROM holds WHERE user_id = ($1) AND status = ($3)
+		) w`
+	err := tx.QueryRow(queryGetTotals, userID, WithdrawalRejected, HoldActive, now.Add(-24*time.Hour), now.Add(-limits.VelocityWindow)).
+		Scan(&totals.dailyCount, &totals.dailySum, &totals.recentCount)
+	if err != nil {
+		return "", err
+	}
+
+	return limits.reviewReason(sum, totals)
+}
+
+// withdrawalTotals - списания и активные блокировки пользователя за последние 24 часа и за VelocityWindow.
+type withdrawalTotals struct {
+	dailyCount  int
+	dailySum    float64
+	recentCount int
+}
+
+// checkRequest проверяет лимиты, для которых не нужна история списаний пользователя.
+func (limits WithdrawalLimits) checkRequest(sum float64, registeredAt sql.NullTime, now time.Time) error {
+	if limits.MaxSum > 0 && sum > limits.MaxSum {
+		return ErrWithdrawalLimitExceeded
+	}
+	// у пользователей, зарегистрированных до появления created_at, возраст не проверяется
+	if limits.MinAccountAge > 0 && registeredAt.Valid && now.Sub(registeredAt.Time) < limits.MinAccountAge {
+		return ErrAccountTooNew
+	}
+	return nil
+}
+
+// reviewReason проверяет дневные лимиты с учётом уже сделанных списаний и возвращает причину ручной проверки.
+func (limits WithdrawalLimits) reviewReason(sum float64, totals withdrawalTotals) (string, error) {
+	if limits.DailyCount > 0 && totals.dailyCount+1 > limits.DailyCount {
+		return "", ErrWithdrawalLimitExceeded
+	}
+	if limits.DailySum > 0 && roundPoints(totals.dailySum+sum) > limits.DailySum {
+		return "", ErrWithdrawalLimitExceeded
+	}
+
+	if limits.VelocityCount > 0 && limits.VelocityWindow > 0 && totals.recentCount >= limits.VelocityCount {
+		return fmt.Sprintf("%d withdrawals within %s", totals.recentCount+1, limits.VelocityWindow), nil
+	}
+	if limits.ReviewSum > 0 && sum >= limits.ReviewSum {
+		return reviewLargeSum, nil
+	}
+	return "", nil
+}
+
+func (r *RepoDB) GetWithdrawalsForReview() ([]entity.ReviewWithdrawal, error) {
+	var withdrawals []entity.ReviewWithdrawal
+	queryGetWithdrawals := `SELECT w.order_id, w.user_id, u.login, w.sum, w.processed_at, COALESCE(w.review_reason, '') AS review_reason
+		FROM withdrawals w JOIN users u ON u.user_id = w.user_id
+		WHERE w.status = ($1) ORDER BY w.processed_at ASC`
+	err := r.db.Select(&withdrawals, queryGetWithdrawals, WithdrawalReview)
+	if err != nil {
+		return nil, err
+	}
+	return withdrawals, nil
+}
+
+// lockWithdrawalForReview блокирует списание, ожидающее решения администратора.
+func lockWithdrawalForReview(tx *sql.Tx, orderID string) (string, float64, error) {
+	var ownerID, status string
+	var sum float64
+	queryGetWithdrawal := `SELECT user_id::text, sum, status FROM withdrawals WHERE order_id = ($1) AND refunded_at IS NULL FOR UPDATE`
+	err := tx.QueryRow(queryGetWithdrawal, orderID).Scan(&ownerID, &sum, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", 0, ErrWithdrawalNotFound
+		}
+		return "", 0, err
+	}
+	if status != WithdrawalReview {
+		return "", 0, ErrWithdrawalNotInReview
+	}
+	return ownerID, sum, nil
+}
+
+func (r *RepoDB) ApproveWithdrawal(orderID string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	if _, _, err := lockWithdrawalForReview(tx, orderID); err != nil {
+		return err
+	}
+
+	queryApprove := `UPDATE withdrawals SET status = ($1), reviewed_at = ($2) WHERE order_id = ($3) AND refunded_at IS NULL`
+	_, err = tx.Exec(queryApprove, WithdrawalProcessed, time.Now().Truncate(time.Second), orderID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RejectWithdrawal отклоняет списание и возвращает баллы так же, как возврат администратором.
+func (r *RepoDB) RejectWithdrawal(orderID string, reason string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	ownerID, sum, err := lockWithdrawalForReview(tx, orderID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Truncate(time.Second)
+	queryReject := `UPDATE withdrawals SET status = ($1), reviewed_at = ($2) WHERE order_id = ($3) AND refunded_at IS NULL`
+	_, err = tx.Exec(queryReject, WithdrawalRejected, now, orderID)
+	if err != nil {
+		return err
+	}
+
+	err = refund(tx, orderID, ownerID, sum, reason, RefundedByAdmin, now)
+	if err != nil {
+		return err
+	}
+
+	err = r.notifyBalance(tx, ownerID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithdrawalLimitsCheckRequest(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	limits := WithdrawalLimits{MaxSum: 500, MinAccountAge: 24 * time.Hour}
+
+	tests := []struct {
+		name         string
+		limits       WithdrawalLimits
+		sum          float64
+		registeredAt sql.NullTime
+		want         error
+	}{
+		{"no limits", WithdrawalLimits{}, 1e6, sql.NullTime{Time: now, Valid: true}, nil},
+		{"at max sum", limits, 500, sql.NullTime{}, nil},
+		{"above max sum", limits, 500.01, sql.NullTime{}, ErrWithdrawalLimitExceeded},
+		{"new account", limits, 10, sql.NullTime{Time: now.Add(-time.Hour), Valid: true}, ErrAccountTooNew},
+		{"old enough account", limits, 10, sql.NullTime{Time: now.Add(-24 * time.Hour), Valid: true}, nil},
+		{"unknown registration time", limits, 10, sql.NullTime{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.limits.checkRequest(tt.sum, tt.registeredAt, now); !errors.Is(err, tt.want) {
+				t.Errorf("checkRequest() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithdrawalLimitsReviewReason(t *testing.T) {
+	limits := WithdrawalLimits{
+		DailySum:       1000,
+		DailyCount:     5,
+		VelocityCount:  3,
+		VelocityWindow: 10 * time.Minute,
+		ReviewSum:      300,
+	}
+
+	tests := []struct {
+		name       string
+		limits     WithdrawalLimits
+		sum        float64
+		totals     withdrawalTotals
+		wantReason string
+		wantErr    error
+	}{
+		{"no limits", WithdrawalLimits{}, 1e6, withdrawalTotals{dailyCount: 100, dailySum: 1e6, recentCount: 100}, "", nil},
+		{"ordinary", limits, 100, withdrawalTotals{dailyCount: 1, dailySum: 100, recentCount: 1}, "", nil},
+		{"daily count reached", limits, 100, withdrawalTotals{dailyCount: 5, dailySum: 100}, "", ErrWithdrawalLimitExceeded},
+		{"daily sum reached exactly", limits, 100, withdrawalTotals{dailyCount: 1, dailySum: 900}, "", nil},
+		{"daily sum exceeded", limits, 100.01, withdrawalTotals{dailyCount: 1, dailySum: 900}, "", ErrWithdrawalLimitExceeded},
+		{"too frequent", limits, 100, withdrawalTotals{dailyCount: 3, dailySum: 300, recentCount: 3}, "4 withdrawals within 10m0s", nil},
+		{"large sum", limits, 300, withdrawalTotals{}, reviewLargeSum, nil},
+		{"limit wins over review", limits, 300, withdrawalTotals{dailyCount: 5}, "", ErrWithdrawalLimitExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, err := tt.limits.reviewReason(tt.sum, tt.totals)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("reviewReason() error = %v, want %v", err, tt.wantErr)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("reviewReason() = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/devkekops/gophermart/internal/app/client"
+	"github.com/devkekops/gophermart/internal/app/entity"
+	"github.com/devkekops/gophermart/internal/app/logger"
+)
+
+type WorkerConfig struct {
+	InstanceID   string
+	MaxAttempts  int
+	PollInterval time.Duration
+	LeaseTTL     time.Duration
+	BatchSize    int
+	Schedule     ScheduleConfig
+}
+
+type Task struct {
+	userID        string
+	orderID       string
+	provider      string
+	polls         int
+	pollStartedAt time.Time
+}
+
+type Worker struct {
+	id   int
+	repo *RepoDB
+}
+
+func (w *Worker) loop() {
+	// worker в цикле берёт из очереди пачку заказов одного провайдера и шлёт запрос в его систему расчёта:
+	// 		- при статус коде 200 - обновляет status, если status PROCESSED или INVALID - обновляем accrual для заказа, удаляем из очереди,
+	//			если REGISTERED или PROCESSING - обновить статус и назначить время следующего опроса по Schedule, заказ снова заберёт dispatch
+	//		- при статус коде 204 - заказ ещё не зарегистрирован, следующий опрос назначается по Schedule как для NEW
+	//		- при статус коде 429 - можешь прийти не раньше чем через RateLimitDelay, клиент провайдера сам не ходит в систему до конца паузы
+	//		- при ошибках (сеть, 5xx, ошибки декодирования и бд) - увеличивает счётчик попыток заказа и откладывает опрос с экспоненциальной задержкой,
+	//			после MaxAttempts попыток заказ уходит в dead_letters и больше не опрашивается
+	for tasks := range w.repo.taskCh {
+		_, provider := w.repo.providers.Get(tasks[0].provider)
+
+		numbers := make([]string, 0, len(tasks))
+		for _, task := range tasks {
+			numbers = append(numbers, task.orderID)
+		}
+
+		responses, err := provider.GetAccrualInfoBatch(numbers)
+		if err != nil {
+			for _, task := range tasks {
+				w.fail(task, err)
+			}
+			continue
+		}
+
+		for i, task := range tasks {
+			w.handle(task, responses[i])
+		}
+	}
+}
+
+// handle обрабатывает ответ системы расчёта по одному заказу.
+func (w *Worker) handle(task *Task, accrualResp client.AccrualResponse) {
+	if accrualResp.Err != nil {
+		w.fail(task, accrualResp.Err)
+		return
+	}
+
+	switch accrualResp.StatusCode {
+	case http.StatusOK:
+		final, err := w.repo.applyAccrual(task.orderID, accrualResp.Status, accrualResp.Accrual)
+		if err != nil {
+			w.fail(task, err)
+			return
+		}
+		if !final {
+			w.repo.reschedule(task, accrualResp.Status)
+		}
+	case http.StatusNoContent:
+		// заказ ещё не зарегистрирован в системе расчёта - это не ошибка, попытки не расходуются
+		w.repo.reschedule(task, NEW)
+	case http.StatusTooManyRequests:
+		w.repo.postpone(task, w.repo.cfg.Schedule.RateLimitDelay)
+	default:
+		w.fail(task, fmt.Errorf("accrual system responded with status code %d", accrualResp.StatusCode))
+	}
+}
+
+func (w *Worker) fail(task *Task, cause error) {
+	logger.Logger.Error().Msgf("worker #%d task #%v error: %v\n", w.id, task, cause)
+
+	dead, err := w.repo.registerFailure(task, cause)
+	if err != nil {
+		logger.Logger.Error().Msgf("worker #%d task #%v error: %v\n", w.id, task, err)
+		w.repo.postpone(task, w.repo.cfg.Schedule.FailureInterval)
+		return
+	}
+	if dead {
+		logger.Logger.Warn().Msgf("worker #%d task #%v moved to dead letters\n", w.id, task)
+	}
+}
+
+// dispatch забирает в аренду заказы, ожидающие расчёта, и раздаёт их worker-ам пачками
+// не больше BatchSize, в каждой пачке заказы одного провайдера.
+// Аренда с истёкшим сроком может быть перехвачена любой репликой, поэтому заказы
+// упавшей или перезапущенной реплики тоже будут обработаны.
+func (r *RepoDB) dispatch(workersCount int) {
+	batchSize := r.cfg.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	claimLimit := workersCount * batchSize
+
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.wakeCh:
+		}
+
+		for {
+			tasks, err := r.claimTasks(claimLimit)
+			if err != nil {
+				logger.Logger.Err(err).Msg("")
+				break
+			}
+			byProvider := make(map[string][]*Task)
+			reassigned := make(map[string][]string)
+			var providers []string
+			for _, task := range tasks {
+				name, _ := r.provider(task.provider)
+				if name != task.provider {
+					reassigned[name] = append(reassigned[name], task.orderID)
+					task.provider = name
+				}
+				if _, ok := byProvider[name]; !ok {
+					providers = append(providers, name)
+				}
+				byProvider[name] = append(byProvider[name], task)
+			}
+			for name, orderIDs := range reassigned {
+				if err := r.reassignProvider(orderIDs, name); err != nil {
+					logger.Logger.Err(err).Msg("")
+				}
+			}
+			for _, name := range providers {
+				group := byProvider[name]
+				for start := 0; start < len(group); start += batchSize {
+					end := start + batchSize
+					if end > len(group) {
+						end = len(group)
+					}
+					r.taskCh <- group[start:end]
+				}
+			}
+			if len(tasks) < claimLimit {
+				break
+			}
+		}
+	}
+}
+
+// provider возвращает клиента провайдера name. Заказы неизвестного провайдера, например удалённого
+// из конфигурации, обслуживает провайдер по умолчанию, о чём пишется в лог.
+func (r *RepoDB) provider(name string) (string, client.Client) {
+	resolved, c := r.providers.Get(name)
+	if name != "" && resolved != name {
+		logger.Logger.Warn().Msgf("unknown accrual provider %q, using %q\n", name, resolved)
+	}
+	return resolved, c
+}
+
+// reassignProvider сохраняет провайдера, который на самом деле опрашивает заказы,
+// чтобы история заказа и повторные проверки шли к нему.
+func (r *RepoDB) reassignProvider(orderIDs []string, provider string) error {
+	queryReassignProvider := `UPDATE orders SET provider = ($1) WHERE order_id = ANY($2::text[]) AND lease_owner = ($3)`
+	_, err := r.db.Exec(queryReassignProvider, provider, orderIDs, r.cfg.InstanceID)
+	return err
+}
+
+func (r *RepoDB) wake() {
+	select {
+	case r.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+func (r *RepoDB) claimTasks(limit int) ([]*Task, error) {
+	queryClaimOrders := `UPDATE orders SET lease_owner = ($1), lease_expires_at = ($2)
+		WHERE order_id IN (
+			SELECT o.order_id FROM orders o
+			WHERE o.status IN ($3, $4, $5)
+				AND o.review_flagged_at IS NULL
+				AND (o.next_poll_at IS NULL OR o.next_poll_at <= ($6))
+				AND (o.lease_expires_at IS NULL OR o.lease_expires_at < ($6))
+				AND NOT EXISTS (SELECT 1 FROM dead_letters d WHERE d.order_id = o.order_id)
+			ORDER BY o.next_poll_at ASC NULLS FIRST, o.uploaded_at ASC
+			LIMIT ($7)
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING order_id, user_id, COALESCE(provider, ''), polls, COALESCE(poll_started_at, uploaded_at)`
+
+	now := time.Now()
+	rows, err := r.db.Query(queryClaimOrders, r.cfg.InstanceID, now.Add(r.cfg.LeaseTTL), NEW, REGISTERED, PROCESSING, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		task := &Task{}
+		if err := rows.Scan(&task.orderID, &task.userID, &task.provider, &task.polls, &task.pollStartedAt); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, rows.Err()
+}
+
+// reschedule назначает следующий опрос незавершённого заказа или, если заказ
+// опрашивается дольше MaxAge, помечает его для ручной проверки.
+func (r *RepoDB) reschedule(task *Task, status string) {
+	now := time.Now()
+	if r.cfg.Schedule.expired(task.pollStartedAt, now) {
+		queryFlagForReview := `UPDATE orders SET review_flagged_at = ($1), lease_owner = NULL, lease_expires_at = NULL WHERE order_id = ($2) AND lease_owner = ($3)`
+		_, err := r.db.Exec(queryFlagForReview, now, task.orderID, r.cfg.InstanceID)
+		if err != nil {
+			logger.Logger.Err(err).Msg("")
+			return
+		}
+		logger.Logger.Warn().Msgf("task #%v flagged for review after %d polls\n", task, task.polls+1)
+		return
+	}
+
+	queryReschedule := `UPDATE orders SET polls = polls + 1, next_poll_at = ($1), lease_owner = NULL, lease_expires_at = NULL WHERE order_id = ($2) AND lease_owner = ($3)`
+	_, err := r.db.Exec(queryReschedule, now.Add(r.cfg.Schedule.interval(status, task.polls)), task.orderID, r.cfg.InstanceID)
+	if err != nil {
+		logger.Logger.Err(err).Msg("")
+	}
+}
+
+func (r *RepoDB) postpone(task *Task, delay time.Duration) {
+	queryPostpone := `UPDATE orders SET next_poll_at = ($1), lease_owner = NULL, lease_expires_at = NULL WHERE order_id = ($2) AND lease_owner = ($3)`
+	_, err := r.db.Exec(queryPostpone, time.Now().Add(delay), task.orderID, r.cfg.InstanceID)
+	if err != nil {
+		logger.Logger.Err(err).Msg("")
+	}
+}
+
+// applyAccrual - общий для worker-а и webhook-а путь обновления заказа по ответу системы расчёта.
+// Возвращает true, если заказ перешёл в конечный статус и больше не требует опроса.
+func (r *RepoDB) applyAccrual(orderID string, status string, accrual float64) (bool, error) {
+	if !validAccrual(accrual) {
+		return false, fmt.Errorf("%w: %v", ErrInvalidAccrual, accrual)
+	}
+
+	switch status {
+	case REGISTERED, PROCESSING:
+		return false, r.updateStatus(orderID, status)
+	case INVALID:
+		return true, r.finalizeOrder(orderID, INVALID, 0)
+	case PROCESSED:
+		return true, r.finalizeOrder(orderID, PROCESSED, accrual)
+	default:
+		return false, fmt.Errorf("%w %q", ErrUnknownAccrualStatus, status)
+	}
+}
+
+// updateStatus публикует событие, только если статус действительно изменился.
+func (r *RepoDB) updateStatus(orderID string, status string) error {
+	var userID string
+	queryUpdateOrderStatus := `UPDATE orders SET status = ($1) WHERE order_id = ($2) AND status NOT IN ($1, $3, $4) RETURNING user_id::text`
+	err := r.db.QueryRow(queryUpdateOrderStatus, status, orderID, PROCESSED, INVALID).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	return notifyOrder(r.db, userID, entity.OrderUpdate{OrderID: orderID, Status: status})
+}
+
+// finalizeOrder начисляет баллы ровно один раз: заказ переводится в конечный статус
+// только из незавершённого (строка блокируется UPDATE-ом), а баланс пополняется
+// в той же транзакции лишь если обновление заказа прошло.
+// accrual системы расчёта сохраняется в base_accrual, а начисляется с коэффициентом уровня пользователя.
+func (r *RepoDB) finalizeOrder(orderID string, status string, accrual float64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	var userID int64
+	var merchant string
+	processedAt := time.Now().Truncate(time.Second)
+	queryUpdateOrderStatusAccrual := `UPDATE orders SET status = ($1), accrual = ($2), base_accrual = ($2), processed_at = ($6), lease_owner = NULL, lease_expires_at = NULL
+		WHERE order_id = ($3) AND status NOT IN ($4, $5) RETURNING user_id, COALESCE(merchant, '')`
+	err = tx.QueryRow(queryUpdateOrderStatusAccrual, status, accrual, orderID, PROCESSED, INVALID, processedAt).Scan(&userID, &merchant)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Logger.Warn().Msgf("order %s already finalized, skipping\n", orderID)
+			return nil
+		}
+		return err
+	}
+
+	uid := strconv.FormatInt(userID, 10)
+	// пользователь блокируется и без начисления: applyRules считает его заказы
+	err = lockUsers(tx, uid)
+	if err != nil {
+		return err
+	}
+
+	baseAccrual := accrual
+	if accrual > 0 {
+		multiplier, err := r.multiplier(tx, uid)
+		if err != nil {
+			return err
+		}
+
+		credited := roundPoints(accrual * multiplier)
+		querySetCredited := `UPDATE orders SET accrual = ($1), multiplier = ($2) WHERE order_id = ($3)`
+		_, err = tx.Exec(querySetCredited, credited, multiplier, orderID)
+		if err != nil {
+			return err
+		}
+
+		err = r.credit(tx, uid, orderID, credited)
+		if err != nil {
+			return err
+		}
+
+		err = r.updateTier(tx, uid)
+		if err != nil {
+			return err
+		}
+		accrual = credited
+	}
+
+	if status == PROCESSED {
+		err = r.applyRules(tx, uid, orderID, baseAccrual, merchant, processedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	queryDeleteDeadLetter := `DELETE FROM dead_letters WHERE order_id = ($1)`
+	_, err = tx.Exec(queryDeleteDeadLetter, orderID)
+	if err != nil {
+		return err
+	}
+
+	err = notifyOrder(tx, uid, entity.OrderUpdate{OrderID: orderID, Status: status, Accrual: accrual})
+	if err != nil {
+		return err
+	}
+	err = r.notifyBalance(tx, uid)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// заказ уже начислен, поэтому ошибка бонуса не возвращается: приглашение останется ожидающим
+	// и будет проверено снова referralLoop-ом
+	if status == PROCESSED {
+		if err := r.rewardReferral(uid); err != nil {
+			logger.Logger.Err(err).Msg("")
+		}
+	}
+
+	return nil
+}
+
+// registerFailure засчитывает неудачную попытку, только пока реплика держит аренду незавершённого заказа:
+// заказ, перехваченный другой репликой или уже рассчитанный через webhook, не трогается и не попадает в dead_letters.
+func (r *RepoDB) registerFailure(task *Task, cause error) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer rollback(tx)
+
+	var attempts int
+	queryIncAttempts := `UPDATE orders SET attempts = attempts + 1, last_error = ($1), lease_owner = NULL, lease_expires_at = NULL
+		WHERE order_id = ($2) AND lease_owner = ($3) AND status NOT IN ($4, $5) RETURNING attempts`
+	err = tx.QueryRow(queryIncAttempts, cause.Error(), task.orderID, r.cfg.InstanceID, PROCESSED, INVALID).Scan(&attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.Logger.Warn().Msgf("task #%v: lease lost or order already finalized, skipping failure\n", task)
+			return false, nil
+		}
+		return false, err
+	}
+
+	if r.cfg.MaxAttempts <= 0 || attempts < r.cfg.MaxAttempts {
+		querySetNextPoll := `UPDATE orders SET next_poll_at = ($1) WHERE order_id = ($2)`
+		_, err = tx.Exec(querySetNextPoll, time.Now().Add(r.cfg.Schedule.backoff(attempts)), task.orderID)
+		if err != nil {
+			return false, err
+		}
+		return false, tx.Commit()
+	}
+
+	queryAddDeadLetter := `INSERT INTO dead_letters (order_id, user_id, attempts, last_error, failed_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (order_id) DO UPDATE SET attempts = EXCLUDED.attempts, last_error = EXCLUDED.last_error, failed_at = EXCLUDED.failed_at`
+	_, err = tx.Exec(queryAddDeadLetter, task.orderID, task.userID, attempts, cause.Error(), time.Now().Truncate(time.Second))
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}